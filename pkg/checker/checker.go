@@ -2,8 +2,11 @@ package checker
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/foxdalas/deploy-checker/pkg/checker_const"
+	"github.com/foxdalas/deploy-checker/pkg/docker"
 	"github.com/foxdalas/deploy-checker/pkg/elastic"
 	"github.com/foxdalas/deploy-checker/pkg/k8s"
 	log "github.com/sirupsen/logrus"
@@ -12,24 +15,31 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 )
 
 var _ checker.Checker = &Checker{}
 
 func New(version string, logging *log.Entry) *Checker {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Checker{
 		Ver:       version,
 		Logging:   logging,
 		StopCh:    make(chan struct{}),
 		WaitGroup: sync.WaitGroup{},
+		ctx:       ctx,
+		cancel:    cancel,
+		exitCode:  cancelledExitCode,
 	}
 }
 
 func (c *Checker) Init() {
-	k, err := k8s.New(c, c.KubeConfig, c.KubeNamespace, c.Development, c.Parallel)
+	k, err := k8s.New(c, c.KubeConfig, c.KubeNamespace, c.Development, c.DevelopmentProfile, c.Parallel, c.waitOptions(), c.Discovery)
 	if err != nil {
 		c.Log().Fatal()
 	}
@@ -48,24 +58,22 @@ func (c *Checker) Init() {
 		return
 	}
 	if c.Report && !c.MonitoringOnly {
-		var wg sync.WaitGroup
-		exitCode := 0
-		for _, app := range strings.Split(c.Apps, ",") {
-			c.Log().Infof("Starting monitoring deployment for %s", app)
-			wg.Add(1)
-			go func(name string) {
-				err = k.Wait(name, &wg)
-				if err != nil {
-					exitCode = 1
-				}
-			}(app)
-		}
-		c.Log().Info("Waiting for deployment to finish")
-		wg.Wait()
+		if c.PreDeployJobs {
+			atomic.AddInt32(&c.waiting, 1)
+			err = k.RunPreDeployJobs(c.ctx, c.ConfigurationDir)
+			atomic.AddInt32(&c.waiting, -1)
+			if err != nil {
+				c.Log().Errorf("Pre-deploy jobs failed: %s", err)
+				os.Exit(1)
+			}
+		}
+		rollouts := c.waitApps(k, strings.Split(c.Apps, ","))
+		outcome := rolloutOutcome(rollouts)
+		exitCode := outcomeExitCode(outcome)
 		if os.Getenv("ROLLBAR_ACCESS_TOKEN") != "" {
-			c.rollbarReport()
+			c.rollbarReport(outcome, rollouts)
 		}
-		err = c.elasticReport()
+		err = c.elasticReport(outcome, rollouts)
 		if err != nil {
 			exitCode = 2
 		}
@@ -84,9 +92,67 @@ func (c *Checker) Init() {
 		return
 	}
 
+	if c.Diff {
+		c.diffK8s()
+		return
+	}
+
+	if c.Apply {
+		c.applyK8s(k)
+		return
+	}
+
 	c.predeployChecks(c.Prefix, c.Apps)
 }
 
+// waitApps waits for the rollouts of apps in parallel and logs their summary.
+func (c *Checker) waitApps(k rolloutWaiter, apps []string) []k8s.RolloutResult {
+	atomic.AddInt32(&c.waiting, 1)
+	defer atomic.AddInt32(&c.waiting, -1)
+	results := make(chan k8s.RolloutResult, len(apps))
+	for _, app := range apps {
+		c.Log().Infof("Starting monitoring deployment for %s", app)
+		go func(name string) {
+			results <- k.Wait(c.ctx, name)
+		}(app)
+	}
+	c.Log().Info("Waiting for deployment to finish")
+	var rollouts []k8s.RolloutResult
+	for range apps {
+		rollouts = append(rollouts, <-results)
+	}
+	if c.ctx.Err() != nil {
+		c.Log().Error("Deployment monitoring cancelled")
+		os.Exit(1)
+	}
+	// a signal received while the results are reported exits with the rollout outcome
+	atomic.StoreInt32(&c.exitCode, int32(outcomeExitCode(rolloutOutcome(rollouts))))
+	sort.Slice(rollouts, func(i, j int) bool {
+		return rollouts[i].App < rollouts[j].App
+	})
+	c.summary(rollouts)
+	return rollouts
+}
+
+func outcomeExitCode(outcome k8s.RolloutStatus) int {
+	switch outcome {
+	case k8s.RolloutFailed:
+		return 1
+	case k8s.RolloutRolledBack:
+		return 3
+	}
+	return 0
+}
+
+func (c *Checker) waitOptions() k8s.WaitOptions {
+	return k8s.WaitOptions{
+		Timeout:           c.Timeout,
+		RollbackOnFailure: c.RollbackOnFailure,
+		StabilityWindow:   c.StabilityWindow,
+		StallThreshold:    c.StallThreshold,
+	}
+}
+
 func (c *Checker) Log() *log.Entry {
 	return c.Logging
 }
@@ -95,14 +161,20 @@ func (c *Checker) Version() string {
 	return c.Ver
 }
 
+// Stop cancels the checker context. While rollouts, pre-deploy jobs or Apply are in progress,
+// Init exits once every wait has returned, otherwise Stop exits with the rollout outcome, or with
+// cancelledExitCode before any rollout finished.
 func (c *Checker) Stop() {
 	c.Log().Info("shutting things down")
+	c.cancel()
 	close(c.StopCh)
-	os.Exit(0)
+	if atomic.LoadInt32(&c.waiting) == 0 {
+		os.Exit(int(atomic.LoadInt32(&c.exitCode)))
+	}
 }
 
-func (c *Checker) predeployK8s() {
-	k, err := k8s.New(c, c.KubeConfig, c.KubeNamespace, c.Development, c.Parallel)
+func (c *Checker) predeployK8s() ([]string, []k8s.Violation) {
+	k, err := k8s.New(c, c.KubeConfig, c.KubeNamespace, c.Development, c.DevelopmentProfile, c.Parallel, c.waitOptions(), c.Discovery)
 	if err != nil {
 		c.Log().Fatal(err)
 	}
@@ -111,11 +183,89 @@ func (c *Checker) predeployK8s() {
 	} else {
 		c.Log().Infof("Starting pre deploy check")
 	}
-	k.PrepareResources(c.ConfigurationDir, c.Development)
+	k.PrepareResources(c.ConfigurationDir, k8s.Image{Repository: c.DockerRepository, Tag: c.DockerTag}, c.digestResolver(), c.Variables)
+	for _, dir := range k.RenderedDirs() {
+		c.Log().Warnf("%s is rendered outside %s, kubectl apply of %s won't deploy it, use -apply or -parallel", dir, c.ConfigurationDir, c.ConfigurationDir)
+	}
+	return k.Images(), k.Lint(c.LintConfig)
+}
+
+// applyK8s applies the processed manifests with server-side apply and waits for the applied workloads.
+func (c *Checker) applyK8s(k applier) {
+	k.PrepareResources(c.ConfigurationDir, k8s.Image{Repository: c.DockerRepository, Tag: c.DockerTag}, c.digestResolver(), c.Variables)
+	atomic.AddInt32(&c.waiting, 1)
+	results, err := k.Apply(c.ctx, c.FieldManager, c.PreDeployJobs)
+	atomic.AddInt32(&c.waiting, -1)
+	if err != nil {
+		c.Log().Fatal(err)
+	}
+	var apps []string
+	for _, r := range results {
+		if r.Workload() && r.Namespace == c.KubeNamespace {
+			apps = append(apps, r.Name)
+		}
+	}
+	if len(apps) == 0 {
+		c.Log().Info("No workloads applied, nothing to wait for")
+		return
+	}
+	os.Exit(outcomeExitCode(rolloutOutcome(c.waitApps(k, apps))))
+}
+
+func (c *Checker) digestResolver() k8s.DigestResolver {
+	if !c.PinDigests {
+		return nil
+	}
+	r, err := docker.New(c, c.DockerConfig)
+	if err != nil {
+		c.Log().Fatal(err)
+	}
+	return r
+}
+
+// diffK8s prints what deploying the manifests would change and exits with 2 when anything differs.
+func (c *Checker) diffK8s() {
+	k, err := k8s.New(c, c.KubeConfig, c.KubeNamespace, c.Development, c.DevelopmentProfile, c.Parallel, c.waitOptions(), c.Discovery)
+	if err != nil {
+		c.Log().Fatal(err)
+	}
+	k.PrepareResources(c.ConfigurationDir, k8s.Image{Repository: c.DockerRepository, Tag: c.DockerTag}, c.digestResolver(), c.Variables)
+	changed, err := k.Diff(os.Stdout)
+	if err != nil {
+		c.Log().Fatal(err)
+	}
+	if changed > 0 {
+		c.Log().Infof("%d objects differ from the cluster", changed)
+		os.Exit(2)
+	}
+	c.Log().Info("Cluster is up to date")
+}
+
+func (c *Checker) predeployDocker(images []string) {
+	r, err := docker.New(c, c.DockerConfig)
+	if err != nil {
+		c.Log().Fatal(err)
+	}
+	var missing []string
+	for _, image := range images {
+		exists, err := r.ImageExists(image)
+		if err != nil {
+			c.Log().Fatalf("Can't check image %s: %s", image, err)
+		}
+		if !exists {
+			c.Log().Errorf("Image %s is not found in registry", image)
+			missing = append(missing, image)
+			continue
+		}
+		c.Log().Infof("Image %s exists", image)
+	}
+	if len(missing) > 0 {
+		c.Log().Fatalf("Images are missing in registry: %s", strings.Join(missing, ", "))
+	}
 }
 
 func (c *Checker) monitoringK8s() {
-	k, err := k8s.New(c, c.KubeConfig, c.KubeNamespace, c.Development, c.Parallel)
+	k, err := k8s.New(c, c.KubeConfig, c.KubeNamespace, c.Development, c.DevelopmentProfile, c.Parallel, c.waitOptions(), c.Discovery)
 	if err != nil {
 		c.Log().Fatal(err)
 	}
@@ -169,31 +319,115 @@ func (c *Checker) monitoringK8s() {
 }
 
 func (c *Checker) predeployChecks(prefix string, apps string) {
-	c.predeployK8s()
+	images, violations := c.predeployK8s()
+	errors := 0
+	for _, v := range violations {
+		if v.Severity == k8s.SeverityError {
+			c.Log().Error(v)
+			errors++
+		} else {
+			c.Log().Warn(v)
+		}
+	}
+	if errors > 0 {
+		c.Log().Fatalf("Manifests have %d lint errors", errors)
+	}
 	if !c.SkipCheckImage {
-		//c.predeployDocker(images)
+		c.predeployDocker(images)
 	}
 	c.Log().Info("All checks passed")
 }
 
-func (c *Checker) elasticReport() error {
+// rolloutOutcome is failed if any app failed, rolled back if any app was rolled back and success otherwise.
+func rolloutOutcome(rollouts []k8s.RolloutResult) k8s.RolloutStatus {
+	outcome := k8s.RolloutSucceeded
+	for _, r := range rollouts {
+		switch r.Status {
+		case k8s.RolloutFailed, k8s.RolloutCancelled:
+			return k8s.RolloutFailed
+		case k8s.RolloutRolledBack:
+			outcome = k8s.RolloutRolledBack
+		}
+	}
+	return outcome
+}
+
+func failedApps(rollouts []k8s.RolloutResult) []string {
+	var apps []string
+	for _, r := range rollouts {
+		if r.Status != k8s.RolloutSucceeded {
+			apps = append(apps, r.App)
+		}
+	}
+	return apps
+}
+
+func (c *Checker) summary(rollouts []k8s.RolloutResult) {
+	c.Log().Info("Rollout summary")
+	for _, r := range rollouts {
+		logger := c.Log().WithFields(log.Fields{
+			"app":      r.App,
+			"kind":     r.Kind,
+			"status":   r.Status,
+			"duration": r.Duration.Round(time.Second),
+			"revision": r.Revision,
+			"replicas": r.Replicas,
+		})
+		if r.Err != nil {
+			logger.WithField("error", r.Err).Error("Rollout failed")
+		} else {
+			logger.Info("Rollout finished")
+		}
+	}
+}
+
+func (c *Checker) elasticReport(outcome k8s.RolloutStatus, rollouts []k8s.RolloutResult) error {
 	e, err := elastic.New(c, c.ElasticSearchURL)
 	if err != nil {
 		c.Log().Error(err)
 		return err
 	}
-	e.Notify(c.Apps, "deploy_log", c.User, c.KubeNamespace, c.DockerTag)
+
+	var results []elastic.AppResult
+	for _, r := range rollouts {
+		result := elastic.AppResult{
+			App:      r.App,
+			Kind:     r.Kind,
+			Status:   string(r.Status),
+			Duration: r.Duration.Seconds(),
+			Revision: r.Revision,
+			Replicas: r.Replicas,
+		}
+		if r.Err != nil {
+			result.Error = r.Err.Error()
+		}
+		if r.Diagnostics != nil {
+			result.Diagnostics = r.Diagnostics.String()
+		}
+		results = append(results, result)
+	}
+	e.Notify(c.Apps, "deploy_log", c.User, c.KubeNamespace, c.DockerTag, string(outcome), results)
 	return nil
 }
 
-func (c *Checker) rollbarReport() {
+func (c *Checker) rollbarReport(outcome k8s.RolloutStatus, rollouts []k8s.RolloutResult) {
+	comment := os.Getenv("ROLLBAR_COMMENT")
+	status := "succeeded"
+	if outcome != k8s.RolloutSucceeded {
+		status = "failed"
+		if comment != "" {
+			comment += ". "
+		}
+		comment += fmt.Sprintf("Rollout %s for %s", outcome, strings.Join(failedApps(rollouts), ", "))
+	}
 
 	data := rollbarData{
 		AccessToken:   os.Getenv("ROLLBAR_ACCESS_TOKEN"),
 		Environment:   os.Getenv("DATACENTER"),
 		Revision:      os.Getenv("COMMIT_HASH"),
 		LocalUsername: c.User,
-		Comment:       os.Getenv("ROLLBAR_COMMENT"),
+		Comment:       comment,
+		Status:        status,
 	}
 
 	b, err := json.Marshal(data)
@@ -214,7 +448,7 @@ func (c *Checker) rollbarReport() {
 }
 
 func (c *Checker) checkDeployments() {
-	_, err := k8s.New(c, c.KubeConfig, c.KubeNamespace, c.Development, c.Parallel)
+	_, err := k8s.New(c, c.KubeConfig, c.KubeNamespace, c.Development, c.DevelopmentProfile, c.Parallel, c.waitOptions(), c.Discovery)
 	if err != nil {
 		c.Log().Fatal(err)
 	}
@@ -1,9 +1,12 @@
 package checker
 
 import (
+	"context"
+	"github.com/foxdalas/deploy-checker/pkg/k8s"
 	log "github.com/sirupsen/logrus"
 	"k8s.io/api/extensions/v1beta1"
 	"sync"
+	"time"
 )
 
 type Checker struct {
@@ -13,11 +16,16 @@ type Checker struct {
 	User string
 
 	ConfigurationDir string
+	Discovery        k8s.Discovery
+	LintConfig       k8s.LintConfig
+	Variables        k8s.Variables
 
 	//Docker
 	DockerRepository string
 	DockerTag        string
-	SkipCheckImage   bool //Todo Remove
+	SkipCheckImage   bool
+	DockerConfig     string
+	PinDigests       bool
 
 	//K8S
 	KubeConfig    string
@@ -33,16 +41,47 @@ type Checker struct {
 	CurrentDeployment *v1beta1.Deployment
 	InRepoDeployment  *v1beta1.Deployment
 
-	DeployProgress  bool
-	Report          bool
-	Development     bool
-	MonitoringRules string
-	MonitoringOnly  bool
+	DeployProgress     bool
+	Diff               bool
+	Apply              bool
+	FieldManager       string
+	Report             bool
+	Development        bool
+	DevelopmentProfile k8s.DevelopmentProfile
+	MonitoringRules    string
+	MonitoringOnly     bool
 
 	Parallel bool
 
+	Timeout           time.Duration
+	RollbackOnFailure bool
+	PreDeployJobs     bool
+	StabilityWindow   time.Duration
+	StallThreshold    time.Duration
+
 	StopCh    chan struct{}
 	WaitGroup sync.WaitGroup
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// waiting counts the waits that return on cancellation, exitCode is the outcome of the finished rollouts,
+	// cancelledExitCode until they finish
+	waiting  int32
+	exitCode int32
+}
+
+// cancelledExitCode is the exit code of a run stopped by a signal, so that a cancelled -diff doesn't read as no changes
+const cancelledExitCode = 130
+
+type rolloutWaiter interface {
+	Wait(ctx context.Context, name string) k8s.RolloutResult
+}
+
+type applier interface {
+	rolloutWaiter
+	PrepareResources(dir string, image k8s.Image, resolver k8s.DigestResolver, variables k8s.Variables)
+	Apply(ctx context.Context, fieldManager string, preDeployJobs bool) ([]k8s.ApplyResult, error)
 }
 
 type rollbarData struct {
@@ -51,4 +90,5 @@ type rollbarData struct {
 	Revision      string `json:"revision"`
 	LocalUsername string `json:"local_username"`
 	Comment       string `json:"comment"`
+	Status        string `json:"status"`
 }
@@ -3,7 +3,10 @@ package cmd
 import (
 	"errors"
 	"flag"
+	"fmt"
 	"github.com/foxdalas/deploy-checker/pkg/checker"
+	"github.com/foxdalas/deploy-checker/pkg/docker"
+	"github.com/foxdalas/deploy-checker/pkg/k8s"
 	log "github.com/sirupsen/logrus"
 	"k8s.io/client-go/util/homedir"
 	"os"
@@ -56,16 +59,28 @@ func makeLog() *log.Entry {
 }
 
 func params(c *checker.Checker) error {
+	var err error
 	c.Log().Infof("Checker %s starting", c.Ver)
 
 	flag.BoolVar(&c.DeployProgress, "processing", false, "Checking kubernetes deploy progress")
 	flag.BoolVar(&c.Report, "report", false, "Send deploy state to elasticsearch")
+	flag.BoolVar(&c.Apply, "apply", false, "Apply processed manifests with server-side apply and wait for the applied workloads")
+	flag.StringVar(&c.FieldManager, "field-manager", "deploy-checker", "Field manager name for server-side apply")
+	flag.BoolVar(&c.Diff, "diff", false, "Print the difference between processed manifests and the cluster, exit with 2 when anything changed")
 	flag.StringVar(&c.MonitoringRules, "monitoring", "monitoring", "Deploy monitoring")
 	flag.BoolVar(&c.MonitoringOnly, "mon-only", false, "Only upload alert rules")
-	flag.BoolVar(&c.SkipCheckImage, "skip-docker-check", true, "Skip verify docker image in docker hub")
+	flag.BoolVar(&c.SkipCheckImage, "skip-docker-check", false, "Skip checking that every image of the processed manifests exists in its registry")
+	lintConfig := flag.String("lint-config", "", "YAML file with disabled lint rules and severity overrides")
 	flag.BoolVar(&c.Development, "development", false, "Change deployment for development environment. Cleanup resources, nodeSelector...")
+	developmentProfile := flag.String("development-profile", "", "YAML file with development transforms replacing the defaults and per-app overrides, used by -development")
 
 	flag.StringVar(&c.ConfigurationDir, "dir", ".", "Configuration directory")
+	discoveryConfig := flag.String("discovery-config", "", "YAML file with include and exclude globs for manifest discovery")
+	include := flag.String("include", "", "Comma separated globs of manifest files, overrides -discovery-config")
+	exclude := flag.String("exclude", "", "Comma separated globs of skipped files and directories, overrides -discovery-config")
+
+	substitute := flag.Bool("substitute", false, "Replace ${NAME} in manifests with environment variables, $${NAME} is kept as ${NAME}")
+	values := flag.String("values", "", "YAML file with values for ${NAME} substitution, implies -substitute. Environment variables take precedence")
 
 	if home := homedir.HomeDir(); home != "" {
 		flag.StringVar(&c.KubeConfig, "kubeconfig", filepath.Join(home, ".kube", "config"), "(optional) absolute path to the kubeconfig file")
@@ -76,6 +91,8 @@ func params(c *checker.Checker) error {
 
 	flag.StringVar(&c.DockerRepository, "repository", "", "Docker repository")
 	flag.StringVar(&c.DockerTag, "tag", "", "Docker repository tag")
+	flag.BoolVar(&c.PinDigests, "pin-digests", false, "Replace image tags with registry digests in generated manifests")
+	flag.StringVar(&c.DockerConfig, "docker-config", docker.DefaultConfigPath(homedir.HomeDir()), "Docker config.json with registry credentials")
 
 	flag.StringVar(&c.User, "user", "ci", "Run user")
 
@@ -84,10 +101,57 @@ func params(c *checker.Checker) error {
 
 	flag.BoolVar(&c.Parallel, "parallel", false, "Enable parallel deploy via .deploy")
 
+	flag.DurationVar(&c.Timeout, "timeout", 0, "Rollout timeout for every app, 0 waits for the progress deadline. Overridden by the deploy-checker/rollout-timeout annotation")
+	flag.BoolVar(&c.PreDeployJobs, "pre-deploy-jobs", false, "Run jobs annotated with deploy-checker/pre-deploy from -dir and wait for them before monitoring apps, or before applying the workloads with -apply")
+	flag.DurationVar(&c.StabilityWindow, "stability-window", 0, "Keep watching new pods for this long after a successful rollout and fail on restarts or lost availability")
+	flag.DurationVar(&c.StallThreshold, "stall-threshold", 0, "Fail a rollout when new pods stay in ImagePullBackOff, ErrImagePull, CreateContainerConfigError or CrashLoopBackOff for this long, 0 waits for the progress deadline")
+	flag.BoolVar(&c.RollbackOnFailure, "rollback-on-failure", false, "Roll back a Deployment to its previous revision when its rollout fails")
+
 	c.ElasticSearchURL = strings.Split(os.Getenv("ELASTICSEARCH_URL"), ",")
 
 	flag.Parse()
 
+	c.Discovery = k8s.DefaultDiscovery()
+	if *discoveryConfig != "" {
+		c.Discovery, err = k8s.LoadDiscovery(*discoveryConfig)
+		if err != nil {
+			return fmt.Errorf("Can't read discovery config %s: %s", *discoveryConfig, err)
+		}
+	}
+	if *include != "" {
+		c.Discovery.Include = strings.Split(*include, ",")
+	}
+	if *exclude != "" {
+		c.Discovery.Exclude = strings.Split(*exclude, ",")
+	}
+	if *values != "" {
+		c.Variables, err = k8s.LoadVariables(*values)
+		if err != nil {
+			return fmt.Errorf("Can't read values %s: %s", *values, err)
+		}
+	} else if *substitute {
+		c.Variables = k8s.Variables{}
+	}
+	c.DevelopmentProfile = k8s.DefaultDevelopmentProfile()
+	if *developmentProfile != "" {
+		c.DevelopmentProfile, err = k8s.LoadDevelopmentProfile(*developmentProfile)
+		if err != nil {
+			return fmt.Errorf("Can't read development profile %s: %s", *developmentProfile, err)
+		}
+	}
+	if *lintConfig != "" {
+		c.LintConfig, err = k8s.LoadLintConfig(*lintConfig)
+		if err != nil {
+			return fmt.Errorf("Can't read lint config %s: %s", *lintConfig, err)
+		}
+	}
+	if len(c.MonitoringRules) > 0 {
+		err = c.Discovery.ExcludeDir(c.ConfigurationDir, c.MonitoringRules)
+		if err != nil {
+			return fmt.Errorf("Can't exclude monitoring directory %s: %s", c.MonitoringRules, err)
+		}
+	}
+
 	if c.Apps == "" && len(c.MonitoringRules) == 0 {
 		return errors.New("Please provide -apps option")
 	}
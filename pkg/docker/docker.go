@@ -0,0 +1,347 @@
+package docker
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/foxdalas/deploy-checker/pkg/checker_const"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// New creates a Docker Registry HTTP API v2 client. Credentials are read from the
+// docker config.json at configPath, a missing file means anonymous access.
+func New(checker checker.Checker, configPath string) (*registry, error) {
+	config, err := readConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+	return &registry{
+		checker: checker,
+		client:  &http.Client{Timeout: 30 * time.Second},
+		config:  config,
+		tokens:  make(map[string]string),
+	}, nil
+}
+
+// DefaultConfigPath returns $DOCKER_CONFIG/config.json or ~/.docker/config.json.
+func DefaultConfigPath(home string) string {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return filepath.Join(dir, "config.json")
+	}
+	return filepath.Join(home, ".docker", "config.json")
+}
+
+func readConfig(path string) (configFile, error) {
+	config := configFile{}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return config, err
+	}
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		return config, fmt.Errorf("can't parse %s: %s", path, err)
+	}
+	return config, nil
+}
+
+// parseReference splits an image the way docker does: the first path component is a
+// registry only if it looks like a host, images without one come from Docker Hub.
+func parseReference(image string) reference {
+	ref := reference{Registry: dockerHubRegistry, Reference: "latest"}
+	name := image
+	if i := strings.Index(name, "@"); i >= 0 {
+		ref.Reference = name[i+1:]
+		name = name[:i]
+	} else if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		ref.Reference = name[i+1:]
+		name = name[:i]
+	}
+
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		ref.Registry = parts[0]
+		name = parts[1]
+	}
+	if ref.Registry == "docker.io" || ref.Registry == "index.docker.io" {
+		ref.Registry = dockerHubRegistry
+	}
+	if ref.Registry == dockerHubRegistry && !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+	ref.Repository = name
+	return ref
+}
+
+func (r reference) scheme() string {
+	host := strings.Split(r.Registry, ":")[0]
+	if host == "localhost" || strings.HasPrefix(host, "127.") {
+		return "http"
+	}
+	return "https"
+}
+
+// credentials looks up a registry the way docker does: in credHelpers, then in credsStore and
+// then in auths. A credential helper without credentials for the registry falls back to auths.
+func (r *registry) credentials(registry string) (string, string, bool) {
+	keys := []string{registry, "https://" + registry, "http://" + registry}
+	hostname, serverURL := registry, registry
+	if registry == dockerHubRegistry {
+		keys = []string{dockerHubIndex, "docker.io", "index.docker.io"}
+		hostname, serverURL = dockerHubHostname, dockerHubIndex
+	}
+	helper := r.config.CredHelpers[hostname]
+	if helper == "" {
+		helper = r.config.CredsStore
+	}
+	if helper != "" {
+		username, password, ok, err := helperCredentials(helper, serverURL)
+		if err != nil {
+			r.Log().Warnf("Can't get credentials for registry %s from credential helper %s: %s", registry, helper, err)
+		} else if ok {
+			return username, password, true
+		}
+	}
+
+	for _, key := range keys {
+		auth, ok := r.config.Auths[key]
+		if !ok {
+			continue
+		}
+		if auth.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+			if err != nil {
+				r.Log().Warnf("Invalid auth for registry %s in docker config", key)
+				continue
+			}
+			parts := strings.SplitN(string(decoded), ":", 2)
+			if len(parts) == 2 {
+				return parts[0], parts[1], true
+			}
+		}
+		if auth.Username != "" {
+			return auth.Username, auth.Password, true
+		}
+	}
+	return "", "", false
+}
+
+// helperCredentials runs `docker-credential-<helper> get` with the docker credential helper protocol.
+// It reports false when the helper has no credentials for serverURL.
+func helperCredentials(helper string, serverURL string) (string, string, bool, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(credentialHelperPrefix+helper, "get")
+	cmd.Stdin = strings.NewReader(serverURL)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		output := strings.TrimSpace(stdout.String())
+		if output == credentialsNotFound {
+			return "", "", false, nil
+		}
+		if details := strings.TrimSpace(output + " " + stderr.String()); details != "" {
+			return "", "", false, fmt.Errorf("%s: %s", err, details)
+		}
+		return "", "", false, err
+	}
+	creds := helperResponse{}
+	err := json.Unmarshal(stdout.Bytes(), &creds)
+	if err != nil {
+		return "", "", false, fmt.Errorf("invalid output: %s", err)
+	}
+	return creds.Username, creds.Secret, true, nil
+}
+
+// ImageExists sends a HEAD request for the image manifest.
+func (r *registry) ImageExists(image string) (bool, error) {
+	resp, err := r.manifest(image)
+	if err != nil {
+		return false, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, fmt.Errorf("registry returned %s for image %s", resp.Status, image)
+}
+
+// Digest resolves an image tag to the digest of its manifest.
+func (r *registry) Digest(image string) (string, error) {
+	resp, err := r.manifest(image)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("registry returned %s for image %s", resp.Status, image)
+	}
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return "", fmt.Errorf("registry returned no digest for image %s", image)
+	}
+	return digest, nil
+}
+
+// manifest sends a HEAD request for the image manifest, authenticating with
+// a bearer token or basic auth when the registry asks for it.
+func (r *registry) manifest(image string) (*http.Response, error) {
+	ref := parseReference(image)
+	manifestURL := fmt.Sprintf("%s://%s/v2/%s/manifests/%s", ref.scheme(), ref.Registry, ref.Repository, ref.Reference)
+	scope := "repository:" + ref.Repository + ":pull"
+
+	resp, err := r.head(manifestURL, r.authorization(ref.Registry, scope))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		authorization, err := r.authenticate(ref.Registry, scope, resp.Header.Get("WWW-Authenticate"))
+		if err != nil {
+			return nil, err
+		}
+		return r.head(manifestURL, authorization)
+	}
+	return resp, nil
+}
+
+func (r *registry) head(manifestURL string, authorization string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodHead, manifestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", manifestAccept)
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp, nil
+}
+
+func (r *registry) authorization(registry string, scope string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.tokens[registry+" "+scope]
+}
+
+func (r *registry) authenticate(registry string, scope string, challenge string) (string, error) {
+	username, password, hasCredentials := r.credentials(registry)
+	scheme, params := parseChallenge(challenge)
+
+	var authorization string
+	switch scheme {
+	case "basic":
+		if !hasCredentials {
+			return "", fmt.Errorf("registry %s requires basic auth, but docker config has no credentials for it", registry)
+		}
+		authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+	case "bearer":
+		token, err := r.token(params, scope, username, password, hasCredentials)
+		if err != nil {
+			return "", fmt.Errorf("can't get token for registry %s: %s", registry, err)
+		}
+		authorization = "Bearer " + token
+	default:
+		return "", fmt.Errorf("registry %s asks for unsupported authentication %q", registry, challenge)
+	}
+
+	r.mu.Lock()
+	r.tokens[registry+" "+scope] = authorization
+	r.mu.Unlock()
+	return authorization, nil
+}
+
+func (r *registry) token(params map[string]string, scope string, username string, password string, hasCredentials bool) (string, error) {
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return "", fmt.Errorf("invalid realm %q", params["realm"])
+	}
+	query := realm.Query()
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+	query.Set("scope", scope)
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if hasCredentials {
+		req.SetBasicAuth(username, password)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint returned %s", resp.Status)
+	}
+
+	token := tokenResponse{}
+	err = json.NewDecoder(resp.Body).Decode(&token)
+	if err != nil {
+		return "", err
+	}
+	if token.Token != "" {
+		return token.Token, nil
+	}
+	return token.AccessToken, nil
+}
+
+// parseChallenge parses a WWW-Authenticate header like
+// Bearer realm="https://auth.docker.io/token",service="registry.docker.io"
+func parseChallenge(challenge string) (string, map[string]string) {
+	params := make(map[string]string)
+	parts := strings.SplitN(strings.TrimSpace(challenge), " ", 2)
+	scheme := strings.ToLower(parts[0])
+	if len(parts) < 2 {
+		return scheme, params
+	}
+	for _, param := range splitParams(parts[1]) {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		params[strings.ToLower(strings.TrimSpace(kv[0]))] = strings.Trim(strings.TrimSpace(kv[1]), `"`)
+	}
+	return scheme, params
+}
+
+// splitParams splits on commas outside of quotes, scopes may contain commas.
+func splitParams(s string) []string {
+	var params []string
+	quoted := false
+	start := 0
+	for i, c := range s {
+		switch c {
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				params = append(params, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(params, s[start:])
+}
+
+func (r *registry) Log() *log.Entry {
+	return r.checker.Log().WithField("context", "docker")
+}
@@ -0,0 +1,361 @@
+package docker
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testChecker struct{}
+
+func (testChecker) Version() string {
+	return "test"
+}
+
+func (testChecker) Log() *logrus.Entry {
+	return logrus.NewEntry(logrus.StandardLogger())
+}
+
+// newTestRegistry writes config as the docker config.json and creates a client with it.
+func newTestRegistry(t *testing.T, config configFile) *registry {
+	dir, err := ioutil.TempDir("", "docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	data, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	r, err := New(testChecker{}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func basicAuth(username string, password string) string {
+	return base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+}
+
+func TestParseReference(t *testing.T) {
+	tests := []struct {
+		image string
+		want  reference
+	}{
+		{"nginx", reference{dockerHubRegistry, "library/nginx", "latest"}},
+		{"nginx:1.17", reference{dockerHubRegistry, "library/nginx", "1.17"}},
+		{"foxdalas/app:v1", reference{dockerHubRegistry, "foxdalas/app", "v1"}},
+		{"docker.io/nginx:1.17", reference{dockerHubRegistry, "library/nginx", "1.17"}},
+		{"index.docker.io/foxdalas/app", reference{dockerHubRegistry, "foxdalas/app", "latest"}},
+		{"registry.example.com/team/app:v2", reference{"registry.example.com", "team/app", "v2"}},
+		{"registry.example.com:5000/app:v2", reference{"registry.example.com:5000", "app", "v2"}},
+		{"registry.example.com:5000/app", reference{"registry.example.com:5000", "app", "latest"}},
+		{"localhost/app:dev", reference{"localhost", "app", "dev"}},
+		{"localhost:5000/team/app", reference{"localhost:5000", "team/app", "latest"}},
+		{"team/app@sha256:abc", reference{dockerHubRegistry, "team/app", "sha256:abc"}},
+		{"registry.example.com/app:v1@sha256:abc", reference{"registry.example.com", "app:v1", "sha256:abc"}},
+	}
+
+	for _, tt := range tests {
+		if got := parseReference(tt.image); got != tt.want {
+			t.Errorf("parseReference(%q) = %+v, want %+v", tt.image, got, tt.want)
+		}
+	}
+}
+
+func TestReferenceScheme(t *testing.T) {
+	tests := []struct {
+		registry string
+		want     string
+	}{
+		{dockerHubRegistry, "https"},
+		{"registry.example.com:5000", "https"},
+		{"localhost:5000", "http"},
+		{"127.0.0.1:5000", "http"},
+	}
+
+	for _, tt := range tests {
+		if got := (reference{Registry: tt.registry}).scheme(); got != tt.want {
+			t.Errorf("scheme of %s = %s, want %s", tt.registry, got, tt.want)
+		}
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	tests := []struct {
+		challenge string
+		scheme    string
+		params    map[string]string
+	}{
+		{
+			challenge: `Bearer realm="https://auth.docker.io/token",service="registry.docker.io"`,
+			scheme:    "bearer",
+			params:    map[string]string{"realm": "https://auth.docker.io/token", "service": "registry.docker.io"},
+		},
+		{
+			challenge: `Bearer realm="https://auth.example.com/token",service="registry",scope="repository:team/app:pull,push"`,
+			scheme:    "bearer",
+			params:    map[string]string{"realm": "https://auth.example.com/token", "service": "registry", "scope": "repository:team/app:pull,push"},
+		},
+		{
+			challenge: `Basic realm="Registry Realm"`,
+			scheme:    "basic",
+			params:    map[string]string{"realm": "Registry Realm"},
+		},
+		{
+			challenge: `  BASIC  `,
+			scheme:    "basic",
+			params:    map[string]string{},
+		},
+		{
+			challenge: `Bearer realm="https://auth.example.com/token", service="registry", error`,
+			scheme:    "bearer",
+			params:    map[string]string{"realm": "https://auth.example.com/token", "service": "registry"},
+		},
+		{
+			challenge: "",
+			scheme:    "",
+			params:    map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		scheme, params := parseChallenge(tt.challenge)
+		if scheme != tt.scheme || !reflect.DeepEqual(params, tt.params) {
+			t.Errorf("parseChallenge(%q) = %q, %v, want %q, %v", tt.challenge, scheme, params, tt.scheme, tt.params)
+		}
+	}
+}
+
+func TestSplitParams(t *testing.T) {
+	tests := []struct {
+		s    string
+		want []string
+	}{
+		{`a="1",b="2"`, []string{`a="1"`, `b="2"`}},
+		{`scope="repository:app:pull,push",b=2`, []string{`scope="repository:app:pull,push"`, `b=2`}},
+		{`a=1`, []string{`a=1`}},
+		{``, []string{``}},
+	}
+
+	for _, tt := range tests {
+		if got := splitParams(tt.s); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitParams(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+// testRegistry serves the manifest of team/app:v1 with the given authentication, "bearer",
+// "basic" or "" for anonymous access. The bearer token endpoint accepts user:secret.
+func testRegistry(t *testing.T, auth string) *httptest.Server {
+	const digest = "sha256:0123456789abcdef"
+	mux := http.NewServeMux()
+	var server *httptest.Server
+	mux.HandleFunc("/token", func(w http.ResponseWriter, req *http.Request) {
+		username, password, ok := req.BasicAuth()
+		if !ok || username != "user" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if req.URL.Query().Get("service") != "test-registry" || req.URL.Query().Get("scope") != "repository:team/app:pull" {
+			t.Errorf("unexpected token request %s", req.URL.RawQuery)
+		}
+		json.NewEncoder(w).Encode(tokenResponse{Token: "test-token"})
+	})
+	mux.HandleFunc("/v2/", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodHead {
+			t.Errorf("unexpected %s request", req.Method)
+		}
+		if !strings.Contains(req.Header.Get("Accept"), "application/vnd.docker.distribution.manifest.v2+json") {
+			t.Errorf("manifest request doesn't accept v2 manifests: %s", req.Header.Get("Accept"))
+		}
+		switch auth {
+		case "bearer":
+			if req.Header.Get("Authorization") != "Bearer test-token" {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test-registry"`, server.URL))
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		case "basic":
+			if req.Header.Get("Authorization") != "Basic "+basicAuth("user", "secret") {
+				w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}
+		if req.URL.Path != "/v2/team/app/manifests/v1" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Docker-Content-Digest", digest)
+	})
+	server = httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestRegistryAuth(t *testing.T) {
+	tests := []struct {
+		name   string
+		auth   string
+		config configFile
+		err    bool
+	}{
+		{name: "anonymous", auth: ""},
+		{name: "bearer with credentials", auth: "bearer", config: configFile{Auths: map[string]authConfig{"HOST": {Auth: basicAuth("user", "secret")}}}},
+		{name: "bearer with wrong credentials", auth: "bearer", config: configFile{Auths: map[string]authConfig{"HOST": {Auth: basicAuth("user", "wrong")}}}, err: true},
+		{name: "bearer without credentials", auth: "bearer", err: true},
+		{name: "basic", auth: "basic", config: configFile{Auths: map[string]authConfig{"http://HOST": {Username: "user", Password: "secret"}}}},
+		{name: "basic without credentials", auth: "basic", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := testRegistry(t, tt.auth)
+			host := strings.TrimPrefix(server.URL, "http://")
+			auths := make(map[string]authConfig)
+			for key, auth := range tt.config.Auths {
+				auths[strings.Replace(key, "HOST", host, 1)] = auth
+			}
+			r := newTestRegistry(t, configFile{Auths: auths})
+
+			digest, err := r.Digest(host + "/team/app:v1")
+			if tt.err {
+				if err == nil {
+					t.Errorf("got digest %s, want an error", digest)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if digest != "sha256:0123456789abcdef" {
+				t.Errorf("got digest %s", digest)
+			}
+
+			exists, err := r.ImageExists(host + "/team/app:v1")
+			if err != nil || !exists {
+				t.Errorf("ImageExists(team/app:v1) = %v, %v, want true", exists, err)
+			}
+			exists, err = r.ImageExists(host + "/team/app:v2")
+			if err != nil || exists {
+				t.Errorf("ImageExists(team/app:v2) = %v, %v, want false", exists, err)
+			}
+		})
+	}
+}
+
+// writeCredentialHelper installs a docker-credential-<name> script on PATH that knows
+// user:secret for registry.example.com and nothing else.
+func writeCredentialHelper(t *testing.T, name string) {
+	dir, err := ioutil.TempDir("", "helper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	script := `#!/bin/sh
+read server
+if [ "$server" = "registry.example.com" ]; then
+	echo '{"ServerURL":"registry.example.com","Username":"` + name + `","Secret":"secret"}'
+	exit 0
+fi
+echo "` + credentialsNotFound + `"
+exit 1
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, credentialHelperPrefix+name), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	t.Cleanup(func() { os.Setenv("PATH", path) })
+}
+
+func TestCredentials(t *testing.T) {
+	writeCredentialHelper(t, "store")
+	writeCredentialHelper(t, "helper")
+	tests := []struct {
+		name     string
+		config   configFile
+		registry string
+		username string
+		password string
+		ok       bool
+	}{
+		{
+			name:     "auth field",
+			config:   configFile{Auths: map[string]authConfig{"registry.example.com": {Auth: basicAuth("user", "pa:ss")}}},
+			registry: "registry.example.com",
+			username: "user", password: "pa:ss", ok: true,
+		},
+		{
+			name:     "username and password",
+			config:   configFile{Auths: map[string]authConfig{"https://registry.example.com": {Username: "user", Password: "secret"}}},
+			registry: "registry.example.com",
+			username: "user", password: "secret", ok: true,
+		},
+		{
+			name:     "docker hub",
+			config:   configFile{Auths: map[string]authConfig{dockerHubIndex: {Auth: basicAuth("hub", "secret")}}},
+			registry: dockerHubRegistry,
+			username: "hub", password: "secret", ok: true,
+		},
+		{
+			name:     "invalid auth is skipped",
+			config:   configFile{Auths: map[string]authConfig{"registry.example.com": {Auth: "not base64!", Username: "user", Password: "secret"}}},
+			registry: "registry.example.com",
+		},
+		{
+			name:     "other registry",
+			config:   configFile{Auths: map[string]authConfig{"registry.example.com": {Auth: basicAuth("user", "secret")}}},
+			registry: "other.example.com",
+		},
+		{
+			name:     "credsStore",
+			config:   configFile{CredsStore: "store", Auths: map[string]authConfig{"registry.example.com": {}}},
+			registry: "registry.example.com",
+			username: "store", password: "secret", ok: true,
+		},
+		{
+			name:     "credHelpers take precedence over credsStore",
+			config:   configFile{CredsStore: "store", CredHelpers: map[string]string{"registry.example.com": "helper"}},
+			registry: "registry.example.com",
+			username: "helper", password: "secret", ok: true,
+		},
+		{
+			name:     "credsStore without credentials falls back to auths",
+			config:   configFile{CredsStore: "store", Auths: map[string]authConfig{"other.example.com": {Username: "user", Password: "secret"}}},
+			registry: "other.example.com",
+			username: "user", password: "secret", ok: true,
+		},
+		{
+			name:     "missing credential helper",
+			config:   configFile{CredsStore: "missing", Auths: map[string]authConfig{"registry.example.com": {Username: "user", Password: "secret"}}},
+			registry: "registry.example.com",
+			username: "user", password: "secret", ok: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRegistry(t, tt.config)
+			username, password, ok := r.credentials(tt.registry)
+			if username != tt.username || password != tt.password || ok != tt.ok {
+				t.Errorf("got %q, %q, %v, want %q, %q, %v", username, password, ok, tt.username, tt.password, tt.ok)
+			}
+		})
+	}
+}
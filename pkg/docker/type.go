@@ -0,0 +1,62 @@
+package docker
+
+import (
+	"github.com/foxdalas/deploy-checker/pkg/checker_const"
+	"net/http"
+	"sync"
+)
+
+type registry struct {
+	checker checker.Checker
+
+	client *http.Client
+	config configFile
+
+	mu     sync.Mutex
+	tokens map[string]string
+}
+
+type reference struct {
+	Registry   string
+	Repository string
+	Reference  string
+}
+
+type configFile struct {
+	Auths       map[string]authConfig `json:"auths"`
+	CredsStore  string                `json:"credsStore"`
+	CredHelpers map[string]string     `json:"credHelpers"`
+}
+
+type authConfig struct {
+	Auth     string `json:"auth"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// helperResponse is the output of a credential helper's get command.
+type helperResponse struct {
+	ServerURL string `json:"ServerURL"`
+	Username  string `json:"Username"`
+	Secret    string `json:"Secret"`
+}
+
+type tokenResponse struct {
+	Token       string `json:"token"`
+	AccessToken string `json:"access_token"`
+}
+
+const (
+	dockerHubRegistry = "registry-1.docker.io"
+	dockerHubIndex    = "https://index.docker.io/v1/"
+	dockerHubHostname = "index.docker.io"
+
+	credentialHelperPrefix = "docker-credential-"
+	credentialsNotFound    = "credentials not found in native keychain"
+
+	manifestAccept = "application/vnd.docker.distribution.manifest.v2+json, " +
+		"application/vnd.docker.distribution.manifest.list.v2+json, " +
+		"application/vnd.oci.image.manifest.v1+json, " +
+		"application/vnd.oci.image.index.v1+json, " +
+		"application/vnd.docker.distribution.manifest.v1+prettyjws"
+)
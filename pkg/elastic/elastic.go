@@ -29,17 +29,14 @@ func New(checker checker.Checker, elasticHost []string) (*elasticSearch, error)
 		return nil, err
 	}
 
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-
 	return &elasticSearch{
 		checker: checker,
 		client:  client,
 		index:   "lita" + "-" + time.Now().Local().Format("2006.01.02"),
-		ctx:     ctx,
 	}, nil
 }
 
-func (e *elasticSearch) sendDocument(apps string, tags string, user string, namespace string, build string) {
+func (e *elasticSearch) sendDocument(apps string, tags string, user string, namespace string, build string, outcome string, results []AppResult) {
 	msg := fmt.Sprintf("Deploy apps %s with build %s in namespace %s", apps, build, namespace)
 	datacenter := os.Getenv("DATACENTER")
 	production := "false"
@@ -64,16 +61,21 @@ func (e *elasticSearch) sendDocument(apps string, tags string, user string, name
 		Datacenter: os.Getenv("DATACENTER"),
 		Apps:       strings.Split(apps, ","),
 		Production: production,
+		Outcome:    outcome,
+		Results:    results,
 	}
 
-	_, err := e.client.Index().Index(e.index).Type("doc").BodyJson(message).Do(e.ctx)
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	_, err := e.client.Index().Index(e.index).Type("doc").BodyJson(message).Do(ctx)
 	if err != nil {
 		e.Log().Error(err)
 	}
 }
 
-func (e *elasticSearch) Notify(apps string, tags string, user string, namespace string, build string) {
-	e.sendDocument(apps, tags, user, namespace, build)
+func (e *elasticSearch) Notify(apps string, tags string, user string, namespace string, build string, outcome string, results []AppResult) {
+	e.sendDocument(apps, tags, user, namespace, build, outcome, results)
 }
 
 func NewEsRetrier() *EsRetrier {
@@ -99,7 +101,10 @@ func (r *EsRetrier) Retry(ctx context.Context, retry int, req *http.Request, res
 }
 
 func (e *elasticSearch) isIndexExist() (bool, error) {
-	return e.client.IndexExists(e.index).Do(e.ctx)
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	return e.client.IndexExists(e.index).Do(ctx)
 }
 
 func (e *elasticSearch) Log() *log.Entry {
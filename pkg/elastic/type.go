@@ -4,7 +4,6 @@ import (
 	"github.com/foxdalas/deploy-checker/pkg/checker_const"
 	elastic "github.com/olivere/elastic/v7"
 	"github.com/sirupsen/logrus"
-	"golang.org/x/net/context"
 	"time"
 )
 
@@ -12,25 +11,41 @@ type elasticSearch struct {
 	checker checker.Checker
 	log     *logrus.Entry
 
-	ctx context.Context
-
 	client *elastic.Client
 	index  string
 }
 
 type document struct {
-	Timestamp  time.Time `json:"@timestamp"`
-	User       string    `json:"user"`
-	Namespace  string    `json:"namespace"`
-	Msg        string    `json:"msg"`
-	Tags       string    `json:"tags"`
-	Build      string    `json:"build"`
-	Datacenter string    `json:"datacenter"`
-	Annotags   string    `json:"annotags"`
-	Apps       []string  `json:"apps"`
-	Production string    `json:"production"`
+	Timestamp  time.Time   `json:"@timestamp"`
+	User       string      `json:"user"`
+	Namespace  string      `json:"namespace"`
+	Msg        string      `json:"msg"`
+	Tags       string      `json:"tags"`
+	Build      string      `json:"build"`
+	Datacenter string      `json:"datacenter"`
+	Annotags   string      `json:"annotags"`
+	Apps       []string    `json:"apps"`
+	Production string      `json:"production"`
+	Outcome    string      `json:"outcome"`
+	Results    []AppResult `json:"results,omitempty"`
+}
+
+// AppResult is the rollout outcome of a single app in the deploy document.
+type AppResult struct {
+	App         string  `json:"app"`
+	Kind        string  `json:"kind"`
+	Status      string  `json:"status"`
+	Duration    float64 `json:"duration_seconds"`
+	Revision    string  `json:"revision,omitempty"`
+	Replicas    int32   `json:"replicas"`
+	Error       string  `json:"error,omitempty"`
+	Diagnostics string  `json:"diagnostics,omitempty"`
 }
 
 type EsRetrier struct {
 	backoff elastic.Backoff
 }
+
+const (
+	requestTimeout = 10 * time.Second
+)
@@ -0,0 +1,254 @@
+package k8s
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sort"
+	"time"
+)
+
+// applyOrder lists the kinds other objects depend on, they are applied first in this order.
+// CRDs come first, the other objects are applied once they are established.
+var applyOrder = []string{
+	"CustomResourceDefinition",
+	"Namespace",
+	"ServiceAccount",
+	"ClusterRole",
+	"ClusterRoleBinding",
+	"Role",
+	"RoleBinding",
+	"ConfigMap",
+	"Secret",
+	"PersistentVolumeClaim",
+	"Service",
+}
+
+// applyRank orders the kinds of applyOrder first and, with preDeployJobs, the pre-deploy
+// jobs right after them, so that they complete before the workloads are applied.
+func applyRank(object lintObject, preDeployJobs bool) int {
+	kind := object.obj.GetObjectKind().GroupVersionKind().Kind
+	for i, k := range applyOrder {
+		if k == kind {
+			return i
+		}
+	}
+	if preDeployJobs && isPreDeployJob(object) {
+		return len(applyOrder)
+	}
+	return len(applyOrder) + 1
+}
+
+func isPreDeployJob(object lintObject) bool {
+	job, ok := object.obj.(*batchv1.Job)
+	return ok && job.Annotations[PreDeployAnnotation] == "true"
+}
+
+func isCRD(object lintObject) bool {
+	return object.obj.GetObjectKind().GroupVersionKind().Kind == "CustomResourceDefinition"
+}
+
+// jobTemplateChanged tells whether a live job runs a different pod template than desired.
+// The template of a job is immutable, so such a job has to be recreated.
+func jobTemplateChanged(live *unstructured.Unstructured, desired *unstructured.Unstructured) (bool, error) {
+	liveTemplate, _, err := unstructured.NestedMap(live.Object, "spec", "template")
+	if err != nil {
+		return false, err
+	}
+	desiredTemplate, _, err := unstructured.NestedMap(desired.Object, "spec", "template")
+	if err != nil {
+		return false, err
+	}
+	removeTemplateTimestamps(liveTemplate)
+	removeTemplateTimestamps(desiredTemplate)
+	before, err := json.Marshal(pruneDefaults(liveTemplate, desiredTemplate))
+	if err != nil {
+		return false, err
+	}
+	after, err := json.Marshal(desiredTemplate)
+	if err != nil {
+		return false, err
+	}
+	return !bytes.Equal(before, after), nil
+}
+
+// applyObject sends a single object with server-side apply and tells whether it was created, changed or left as is.
+// Jobs are deleted and created again when their pod template changed, pre-deploy jobs with preDeployJobs every time.
+func (k *k8s) applyObject(ctx context.Context, object lintObject, fieldManager string, preDeployJobs bool) (ApplyResult, error) {
+	desired, client, err := k.desiredObject(object)
+	if err != nil {
+		return ApplyResult{Path: object.path}, err
+	}
+	result := ApplyResult{
+		Path:      object.path,
+		Kind:      desired.GetKind(),
+		Name:      desired.GetName(),
+		Namespace: desired.GetNamespace(),
+	}
+
+	var resourceVersion string
+	live, err := client.Get(ctx, desired.GetName(), metav1.GetOptions{})
+	switch {
+	case errors.IsNotFound(err):
+		result.Action = ApplyCreated
+	case err != nil:
+		return result, err
+	default:
+		resourceVersion = live.GetResourceVersion()
+	}
+	if result.Action == "" && desired.GroupVersionKind() == batchv1.SchemeGroupVersion.WithKind("Job") {
+		recreate := preDeployJobs && isPreDeployJob(object)
+		if !recreate {
+			recreate, err = jobTemplateChanged(live, desired)
+			if err != nil {
+				return result, err
+			}
+		}
+		if recreate {
+			k.Log().Infof("Deleting %s to create it again, the pod template of a job can't be changed", result)
+			err = k.deleteJob(ctx, desired.GetNamespace(), desired.GetName())
+			if err != nil {
+				return result, err
+			}
+			result.Action = ApplyRecreated
+		}
+	}
+
+	data, err := desired.MarshalJSON()
+	if err != nil {
+		return result, err
+	}
+	force := true
+	applied, err := client.Patch(ctx, desired.GetName(), types.ApplyPatchType, data, metav1.PatchOptions{FieldManager: fieldManager, Force: &force})
+	if err != nil {
+		return result, err
+	}
+	if result.Action == "" {
+		result.Action = ApplyConfigured
+		if applied.GetResourceVersion() == resourceVersion {
+			result.Action = ApplyUnchanged
+		}
+	}
+	return result, nil
+}
+
+// Apply sends every object processed by PrepareResources to the cluster with server-side apply,
+// owning the fields under fieldManager. Conflicts with other managers, like kubectl, are forced.
+// With preDeployJobs, the jobs annotated as pre-deploy are run and waited for before the workloads are applied.
+func (k *k8s) Apply(ctx context.Context, fieldManager string, preDeployJobs bool) ([]ApplyResult, error) {
+	k.objectsMu.Lock()
+	objects := append([]lintObject{}, k.objects...)
+	k.objectsMu.Unlock()
+	sort.SliceStable(objects, func(i, j int) bool {
+		ri := applyRank(objects[i], preDeployJobs)
+		rj := applyRank(objects[j], preDeployJobs)
+		if ri != rj {
+			return ri < rj
+		}
+		return objects[i].path < objects[j].path
+	})
+	if preDeployJobs {
+		k.jobs.start(ctx)
+	}
+
+	var results []ApplyResult
+	var crds []lintObject
+	for _, object := range objects {
+		if ctx.Err() != nil {
+			return results, ctx.Err()
+		}
+		if len(crds) > 0 && !isCRD(object) {
+			if err := k.waitEstablished(ctx, crds); err != nil {
+				return results, err
+			}
+			crds = nil
+		}
+		result, err := k.applyObject(ctx, object, fieldManager, preDeployJobs)
+		if err != nil {
+			name := object.path
+			if result.Name != "" {
+				name = fmt.Sprintf("%s from %s", result, object.path)
+			}
+			return results, fmt.Errorf("can't apply %s: %s", name, err)
+		}
+		k.Log().Infof("%s %s", result, result.Action)
+		results = append(results, result)
+		if isCRD(object) {
+			crds = append(crds, object)
+		}
+		if preDeployJobs && isPreDeployJob(object) {
+			if result.Namespace != k.namespace {
+				return results, fmt.Errorf("pre-deploy job %s must be in namespace %s", result, k.namespace)
+			}
+			k.Log().Infof("Waiting for pre-deploy job %s", result.Name)
+			if err := k.waitPreDeployJob(ctx, result.Name); err != nil {
+				return results, err
+			}
+		}
+	}
+	return results, nil
+}
+
+// waitEstablished waits until the API server serves the applied CRDs and refreshes the
+// RESTMapper, so that the custom resources using them can be mapped and applied.
+func (k *k8s) waitEstablished(ctx context.Context, crds []lintObject) error {
+	ctx, cancel := context.WithTimeout(ctx, crdEstablishTimeout)
+	defer cancel()
+	for _, crd := range crds {
+		desired, client, err := k.desiredObject(crd)
+		if err != nil {
+			return err
+		}
+		for {
+			live, err := client.Get(ctx, desired.GetName(), metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			if crdEstablished(live) {
+				break
+			}
+			k.Log().Debugf("Waiting for %s to be established", objectName(desired))
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("%s is not established: %s", objectName(desired), ctx.Err())
+			case <-time.After(crdEstablishInterval):
+			}
+		}
+	}
+	k.resetRESTMapper()
+	return nil
+}
+
+func crdEstablished(crd *unstructured.Unstructured) bool {
+	conditions, _, _ := unstructured.NestedSlice(crd.Object, "status", "conditions")
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if ok && condition["type"] == "Established" && condition["status"] == "True" {
+			return true
+		}
+	}
+	return false
+}
+
+func (r ApplyResult) String() string {
+	name := r.Kind + "/" + r.Name
+	if r.Namespace != "" {
+		name = r.Namespace + "/" + name
+	}
+	return name
+}
+
+// Workload tells whether Wait can follow the rollout of the applied object.
+func (r ApplyResult) Workload() bool {
+	switch r.Kind {
+	case "Deployment", "StatefulSet", "DaemonSet", "Job":
+		return true
+	}
+	return false
+}
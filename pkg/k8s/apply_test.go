@@ -0,0 +1,168 @@
+package k8s
+
+import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"sort"
+	"testing"
+)
+
+func TestApplyRank(t *testing.T) {
+	objects := lintObjects(t, `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+---
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+  annotations:
+    deploy-checker/pre-deploy: "true"
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+---
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: report
+`)
+	crd := &unstructured.Unstructured{}
+	crd.SetAPIVersion("apiextensions.k8s.io/v1")
+	crd.SetKind("CustomResourceDefinition")
+	crd.SetName("certificates.example.com")
+	objects = append(objects, lintObject{path: "manifest.yaml", obj: crd})
+
+	tests := []struct {
+		preDeployJobs bool
+		want          []string
+	}{
+		{false, []string{"CustomResourceDefinition", "ConfigMap", "Deployment", "Job", "Job"}},
+		{true, []string{"CustomResourceDefinition", "ConfigMap", "Job", "Deployment", "Job"}},
+	}
+	for _, tt := range tests {
+		sorted := append([]lintObject{}, objects...)
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return applyRank(sorted[i], tt.preDeployJobs) < applyRank(sorted[j], tt.preDeployJobs)
+		})
+		for i, object := range sorted {
+			if kind := object.obj.GetObjectKind().GroupVersionKind().Kind; kind != tt.want[i] {
+				t.Errorf("preDeployJobs %v: object %d is a %s, want %s", tt.preDeployJobs, i, kind, tt.want[i])
+			}
+		}
+		if tt.preDeployJobs && !isPreDeployJob(sorted[2]) {
+			t.Errorf("the pre-deploy job isn't applied right after the dependencies")
+		}
+	}
+}
+
+func unstructuredObject(t *testing.T, data string) *unstructured.Unstructured {
+	json, err := yaml.ToJSON([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj := &unstructured.Unstructured{}
+	if err := obj.UnmarshalJSON(json); err != nil {
+		t.Fatal(err)
+	}
+	return obj
+}
+
+func TestJobTemplateChanged(t *testing.T) {
+	desired := unstructuredObject(t, `apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+spec:
+  template:
+    metadata:
+      labels:
+        app: migrate
+    spec:
+      restartPolicy: Never
+      containers:
+      - name: migrate
+        image: migrate:v2
+`)
+	tests := []struct {
+		name string
+		live string
+		want bool
+	}{
+		{
+			name: "same template with server defaults",
+			live: `apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+spec:
+  backoffLimit: 6
+  template:
+    metadata:
+      creationTimestamp: null
+      labels:
+        app: migrate
+        controller-uid: 0b5d4a1e
+        job-name: migrate
+    spec:
+      restartPolicy: Never
+      dnsPolicy: ClusterFirst
+      containers:
+      - name: migrate
+        image: migrate:v2
+        imagePullPolicy: IfNotPresent
+        terminationMessagePath: /dev/termination-log
+`,
+		},
+		{
+			name: "new image",
+			live: `apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+spec:
+  template:
+    metadata:
+      labels:
+        app: migrate
+    spec:
+      restartPolicy: Never
+      containers:
+      - name: migrate
+        image: migrate:v1
+`,
+			want: true,
+		},
+		{
+			name: "removed label",
+			live: `apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+spec:
+  template:
+    spec:
+      restartPolicy: Never
+      containers:
+      - name: migrate
+        image: migrate:v2
+`,
+			want: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := jobTemplateChanged(unstructuredObject(t, tt.live), desired)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
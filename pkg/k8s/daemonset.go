@@ -0,0 +1,41 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func (k *k8s) fetchDaemonset(name string) (runtime.Object, error) {
+	return k.client.AppsV1().DaemonSets(k.namespace).Get(context.TODO(), name, metav1.GetOptions{})
+}
+
+func (k *k8s) currentDaemonset(name string) (*appsv1.DaemonSet, error) {
+	obj, err := k.daemonsets.current(name)
+	if err != nil {
+		return nil, err
+	}
+	return obj.(*appsv1.DaemonSet), nil
+}
+
+func (k *k8s) daemonsetInProgress(name string) (string, bool, error) {
+	ds, err := k.currentDaemonset(name)
+	if err != nil {
+		return "", false, err
+	}
+	if ds.Spec.UpdateStrategy.Type != appsv1.RollingUpdateDaemonSetStrategyType {
+		return "", false, fmt.Errorf("daemonset %q uses %s update strategy, rollout status is only available for %s", name, ds.Spec.UpdateStrategy.Type, appsv1.RollingUpdateDaemonSetStrategyType)
+	}
+	if ds.Generation > ds.Status.ObservedGeneration {
+		return fmt.Sprintf("Waiting for daemonset %q spec update to be observed...", name), false, nil
+	}
+	if ds.Status.UpdatedNumberScheduled < ds.Status.DesiredNumberScheduled {
+		return fmt.Sprintf("Waiting for daemonset %q rollout to finish: %d out of %d new pods have been updated...", name, ds.Status.UpdatedNumberScheduled, ds.Status.DesiredNumberScheduled), false, nil
+	}
+	if ds.Status.NumberAvailable < ds.Status.DesiredNumberScheduled {
+		return fmt.Sprintf("Waiting for daemonset %q rollout to finish: %d of %d updated pods are available...", name, ds.Status.NumberAvailable, ds.Status.DesiredNumberScheduled), false, nil
+	}
+	return fmt.Sprintf("daemonset %q successfully rolled out", name), true, nil
+}
@@ -1,13 +1,15 @@
 package k8s
 
 import (
+	"context"
 	"fmt"
 	appsv1 "k8s.io/api/apps/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
 func (k *k8s) getKubernetesDeployment(name string, namespace string) *appsv1.Deployment {
-	obj, err := k.client.AppsV1().Deployments(namespace).Get(name, metav1.GetOptions{})
+	obj, err := k.client.AppsV1().Deployments(namespace).Get(context.TODO(), name, metav1.GetOptions{})
 	if err != nil {
 		k.Log().Fatal(err)
 	}
@@ -30,10 +32,22 @@ func getDeploymentCondition(status appsv1.DeploymentStatus, condType appsv1.Depl
 	return nil
 }
 
+func (k *k8s) fetchDeployment(name string) (runtime.Object, error) {
+	return k.client.AppsV1().Deployments(k.namespace).Get(context.TODO(), name, metav1.GetOptions{})
+}
+
+func (k *k8s) currentDeployment(name string) (*appsv1.Deployment, error) {
+	obj, err := k.deployments.current(name)
+	if err != nil {
+		return nil, err
+	}
+	return obj.(*appsv1.Deployment), nil
+}
+
 func (k *k8s) deploymentInProgress(name string) (string, bool, error) {
-	deployment, err := k.client.AppsV1().Deployments(k.namespace).Get(name, metav1.GetOptions{})
+	deployment, err := k.currentDeployment(name)
 	if err != nil {
-		k.Log().Error(err)
+		return "", false, err
 	}
 	if deployment.Generation <= deployment.Status.ObservedGeneration {
 		cond := getDeploymentCondition(deployment.Status, appsv1.DeploymentProgressing)
@@ -0,0 +1,147 @@
+package k8s
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/ghodss/yaml"
+	"io/ioutil"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// DefaultDevelopmentProfile runs workloads on development nodes with minimal requests.
+func DefaultDevelopmentProfile() DevelopmentProfile {
+	return DevelopmentProfile{
+		DevelopmentTransforms: DevelopmentTransforms{
+			NodeSelector: map[string]string{"kubernetes.io/role": "development"},
+			Resources: &corev1.ResourceRequirements{
+				Limits: corev1.ResourceList{
+					"memory": resource.MustParse("4Gi"),
+				},
+				Requests: corev1.ResourceList{
+					"cpu":    resource.MustParse("1m"),
+					"memory": resource.MustParse("1Mi"),
+				},
+			},
+		},
+	}
+}
+
+// LoadDevelopmentProfile reads a development profile from a YAML file. Fields follow
+// the kubernetes API names, so tolerations and resources are written as in a pod spec.
+// Fields set in the file replace those of DefaultDevelopmentProfile, an empty value like
+// nodeSelector: {} clears them.
+func LoadDevelopmentProfile(path string) (DevelopmentProfile, error) {
+	profile := DevelopmentProfile{}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return profile, err
+	}
+	data, err = yaml.YAMLToJSON(data)
+	if err != nil {
+		return profile, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&profile); err != nil {
+		return profile, err
+	}
+	profile.DevelopmentTransforms = DefaultDevelopmentProfile().with(profile.DevelopmentTransforms)
+	return profile, nil
+}
+
+// with returns the transforms with the fields set in override replaced.
+func (t DevelopmentTransforms) with(override DevelopmentTransforms) DevelopmentTransforms {
+	if override.NodeSelector != nil {
+		t.NodeSelector = override.NodeSelector
+	}
+	if override.Tolerations != nil {
+		t.Tolerations = override.Tolerations
+	}
+	if override.PriorityClassName != "" {
+		t.PriorityClassName = override.PriorityClassName
+	}
+	if override.Replicas != nil {
+		t.Replicas = override.Replicas
+	}
+	if override.Resources != nil {
+		t.Resources = override.Resources
+	}
+	if override.DisableProbes != nil {
+		t.DisableProbes = override.DisableProbes
+	}
+	return t
+}
+
+// forApp returns the profile transforms with the overrides of app on top.
+func (p DevelopmentProfile) forApp(app string) DevelopmentTransforms {
+	return p.DevelopmentTransforms.with(p.Apps[app])
+}
+
+// mergeTolerations adds tolerations to existing, replacing those with the same key and effect.
+func mergeTolerations(existing []corev1.Toleration, tolerations []corev1.Toleration) []corev1.Toleration {
+	merged := append([]corev1.Toleration{}, existing...)
+	for _, toleration := range tolerations {
+		replaced := false
+		for i := range merged {
+			if merged[i].Key == toleration.Key && merged[i].Effect == toleration.Effect {
+				merged[i] = toleration
+				replaced = true
+			}
+		}
+		if !replaced {
+			merged = append(merged, toleration)
+		}
+	}
+	return merged
+}
+
+func (k *k8s) prepareForDevelopment(res *resourcesFile) {
+	decode := scheme.Codecs.UniversalDeserializer().Decode
+	obj, _, err := decode(res.data, nil, nil)
+	if err != nil {
+		k.Log().Fatalf("Development profile Error while decoding YAML object in file %s. Err was: %s", res.path, err)
+	}
+	// DaemonSets and batch workloads keep their scheduling and replicas
+	switch obj.(type) {
+	case *appsv1.Deployment, *appsv1.StatefulSet:
+	default:
+		return
+	}
+	spec := podSpec(obj)
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return
+	}
+	t := k.developmentProfile.forApp(accessor.GetName())
+
+	if t.NodeSelector != nil {
+		spec.NodeSelector = t.NodeSelector
+	}
+	spec.Tolerations = mergeTolerations(spec.Tolerations, t.Tolerations)
+	if t.PriorityClassName != "" {
+		spec.PriorityClassName = t.PriorityClassName
+	}
+	for i := range spec.Containers {
+		if t.Resources != nil {
+			spec.Containers[i].Resources = *t.Resources.DeepCopy()
+		}
+		if t.DisableProbes != nil && *t.DisableProbes {
+			spec.Containers[i].ReadinessProbe = nil
+			spec.Containers[i].LivenessProbe = nil
+		}
+	}
+	if t.Replicas != nil {
+		replicas := *t.Replicas
+		switch o := obj.(type) {
+		case *appsv1.Deployment:
+			o.Spec.Replicas = &replicas
+		case *appsv1.StatefulSet:
+			o.Spec.Replicas = &replicas
+		}
+	}
+	res.data = k.objectToBytes(obj)
+}
@@ -0,0 +1,114 @@
+package k8s
+
+import (
+	"io/ioutil"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const developmentDeployment = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  replicas: 3
+  selector:
+    matchLabels:
+      app: web
+  template:
+    metadata:
+      labels:
+        app: web
+    spec:
+      tolerations:
+      - key: dedicated
+        operator: Equal
+        value: web
+        effect: NoSchedule
+      containers:
+      - name: web
+        image: web:v1
+`
+
+func TestPrepareForDevelopment(t *testing.T) {
+	one := int32(1)
+	k := newTestK8s()
+	k.developmentProfile = DevelopmentProfile{
+		DevelopmentTransforms: DevelopmentTransforms{
+			NodeSelector: map[string]string{"kubernetes.io/role": "development"},
+			Tolerations: []corev1.Toleration{
+				{Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "development", Effect: corev1.TaintEffectNoSchedule},
+				{Key: "spot", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoSchedule},
+			},
+			Replicas: &one,
+		},
+	}
+
+	res := &resourcesFile{path: "web.yaml", data: []byte(developmentDeployment), resourceType: "deployment"}
+	k.prepareForDevelopment(res)
+	obj, _, err := scheme.Codecs.UniversalDeserializer().Decode(res.data, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deployment := obj.(*appsv1.Deployment)
+	if !reflect.DeepEqual(deployment.Spec.Template.Spec.Tolerations, k.developmentProfile.Tolerations) {
+		t.Errorf("got tolerations %+v, want %+v", deployment.Spec.Template.Spec.Tolerations, k.developmentProfile.Tolerations)
+	}
+	if !reflect.DeepEqual(deployment.Spec.Template.Spec.NodeSelector, k.developmentProfile.NodeSelector) {
+		t.Errorf("got node selector %v, want %v", deployment.Spec.Template.Spec.NodeSelector, k.developmentProfile.NodeSelector)
+	}
+	if deployment.Spec.Replicas == nil || *deployment.Spec.Replicas != 1 {
+		t.Errorf("got replicas %v, want 1", deployment.Spec.Replicas)
+	}
+
+	for _, data := range []string{
+		"apiVersion: apps/v1\nkind: DaemonSet\nmetadata:\n  name: agent\nspec:\n  template:\n    spec:\n      containers:\n      - name: agent\n        image: agent:v1\n",
+		"apiVersion: batch/v1\nkind: Job\nmetadata:\n  name: migrate\nspec:\n  template:\n    spec:\n      restartPolicy: Never\n      containers:\n      - name: migrate\n        image: migrate:v1\n",
+	} {
+		res := &resourcesFile{path: "manifest.yaml", data: []byte(data)}
+		k.prepareForDevelopment(res)
+		if string(res.data) != data {
+			t.Errorf("got\n%s\nwant unchanged\n%s", res.data, data)
+		}
+	}
+}
+
+func TestLoadDevelopmentProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "development")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "profile.yaml")
+	data := `priorityClassName: development
+apps:
+  web:
+    nodeSelector: {}
+`
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	profile, err := LoadDevelopmentProfile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaults := DefaultDevelopmentProfile()
+	if profile.PriorityClassName != "development" {
+		t.Errorf("got priority class %q, want development", profile.PriorityClassName)
+	}
+	if !reflect.DeepEqual(profile.NodeSelector, defaults.NodeSelector) {
+		t.Errorf("got node selector %v, want the default %v", profile.NodeSelector, defaults.NodeSelector)
+	}
+	if !reflect.DeepEqual(profile.Resources, defaults.Resources) {
+		t.Errorf("got resources %v, want the default %v", profile.Resources, defaults.Resources)
+	}
+	if web := profile.forApp("web"); len(web.NodeSelector) != 0 || web.PriorityClassName != "development" {
+		t.Errorf("got web transforms %+v, want no node selector and the development priority class", web)
+	}
+}
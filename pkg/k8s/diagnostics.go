@@ -0,0 +1,247 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sort"
+	"strings"
+	"time"
+)
+
+// newPods returns the pods of the revision being rolled out together with the
+// objects that own them, from the workload down to the ReplicaSet.
+func (k *k8s) newPods(name string, kind string) ([]corev1.Pod, []metav1.Object, error) {
+	switch kind {
+	case "deployment":
+		deployment, err := k.client.AppsV1().Deployments(k.namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return nil, nil, err
+		}
+		replicaSets, err := k.replicaSets(deployment)
+		if err != nil {
+			return nil, nil, err
+		}
+		var newest *appsv1.ReplicaSet
+		for i := range replicaSets {
+			if newest == nil || revision(&replicaSets[i]) > revision(newest) {
+				newest = &replicaSets[i]
+			}
+		}
+		if newest == nil {
+			return nil, []metav1.Object{deployment}, nil
+		}
+		pods, err := k.ownedPods(newest.Spec.Selector, newest, nil)
+		return pods, []metav1.Object{deployment, newest}, err
+	case "statefulset":
+		sts, err := k.client.AppsV1().StatefulSets(k.namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return nil, nil, err
+		}
+		pods, err := k.ownedPods(sts.Spec.Selector, sts, func(pod *corev1.Pod) bool {
+			return pod.Labels[appsv1.ControllerRevisionHashLabelKey] == sts.Status.UpdateRevision
+		})
+		return pods, []metav1.Object{sts}, err
+	case "daemonset":
+		ds, err := k.client.AppsV1().DaemonSets(k.namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return nil, nil, err
+		}
+		generation := ds.Annotations[appsv1.DeprecatedTemplateGeneration]
+		pods, err := k.ownedPods(ds.Spec.Selector, ds, func(pod *corev1.Pod) bool {
+			return generation == "" || pod.Labels[extensionsv1beta1.DaemonSetTemplateGenerationKey] == generation
+		})
+		return pods, []metav1.Object{ds}, err
+	case "job":
+		job, err := k.client.BatchV1().Jobs(k.namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return nil, nil, err
+		}
+		pods, err := k.ownedPods(job.Spec.Selector, job, nil)
+		return pods, []metav1.Object{job}, err
+	}
+	return nil, nil, fmt.Errorf("pods of %s %q can't be inspected", kind, name)
+}
+
+func (k *k8s) ownedPods(labelSelector *metav1.LabelSelector, owner metav1.Object, filter func(*corev1.Pod) bool) ([]corev1.Pod, error) {
+	selector, err := metav1.LabelSelectorAsSelector(labelSelector)
+	if err != nil {
+		return nil, err
+	}
+	list, err := k.client.CoreV1().Pods(k.namespace).List(context.TODO(), metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+	var pods []corev1.Pod
+	for i := range list.Items {
+		if !metav1.IsControlledBy(&list.Items[i], owner) {
+			continue
+		}
+		if filter != nil && !filter(&list.Items[i]) {
+			continue
+		}
+		pods = append(pods, list.Items[i])
+	}
+	return pods, nil
+}
+
+// podProblems flags the pod states that explain most failed rollouts. It returns
+// the problems found and the containers whose logs are worth reading.
+func podProblems(pod *corev1.Pod) ([]string, []corev1.ContainerStatus) {
+	var problems []string
+	var failing []corev1.ContainerStatus
+
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodScheduled && cond.Status == corev1.ConditionFalse && cond.Reason == corev1.PodReasonUnschedulable {
+			problems = append(problems, fmt.Sprintf("Unschedulable: %s", cond.Message))
+		}
+	}
+
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		if waiting := status.State.Waiting; waiting != nil {
+			switch waiting.Reason {
+			case "CrashLoopBackOff":
+				problems = append(problems, fmt.Sprintf("container %s: CrashLoopBackOff after %d restarts", status.Name, status.RestartCount))
+				failing = append(failing, status)
+			case "ImagePullBackOff", "ErrImagePull":
+				problems = append(problems, fmt.Sprintf("container %s: %s %s", status.Name, waiting.Reason, waiting.Message))
+			}
+		}
+		if terminated := lastTermination(status); terminated != nil && terminated.Reason == "OOMKilled" {
+			problems = append(problems, fmt.Sprintf("container %s: OOMKilled", status.Name))
+			if status.State.Waiting == nil || status.State.Waiting.Reason != "CrashLoopBackOff" {
+				failing = append(failing, status)
+			}
+		}
+	}
+	return problems, failing
+}
+
+func lastTermination(status corev1.ContainerStatus) *corev1.ContainerStateTerminated {
+	if status.State.Terminated != nil {
+		return status.State.Terminated
+	}
+	return status.LastTerminationState.Terminated
+}
+
+func (k *k8s) containerLogs(pod *corev1.Pod, status corev1.ContainerStatus) string {
+	tail := int64(diagnosticsLogLines)
+	opts := &corev1.PodLogOptions{
+		Container: status.Name,
+		TailLines: &tail,
+		Previous:  status.State.Terminated == nil && status.RestartCount > 0,
+	}
+	logs, err := k.client.CoreV1().Pods(k.namespace).GetLogs(pod.Name, opts).DoRaw(context.TODO())
+	if err != nil {
+		return fmt.Sprintf("failed to get logs: %s", err)
+	}
+	return strings.TrimRight(string(logs), "\n")
+}
+
+func (k *k8s) recentEvents(objects []metav1.Object) ([]string, error) {
+	uids := make(map[types.UID]bool)
+	for _, obj := range objects {
+		uids[obj.GetUID()] = true
+	}
+	list, err := k.client.CoreV1().Events(k.namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var events []corev1.Event
+	for _, event := range list.Items {
+		if uids[event.InvolvedObject.UID] {
+			events = append(events, event)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].LastTimestamp.Before(&events[j].LastTimestamp)
+	})
+	if len(events) > diagnosticsEvents {
+		events = events[len(events)-diagnosticsEvents:]
+	}
+
+	var lines []string
+	for _, event := range events {
+		lines = append(lines, fmt.Sprintf("%s ago %s %s %s/%s: %s",
+			time.Since(event.LastTimestamp.Time).Round(time.Second), event.Type, event.Reason,
+			strings.ToLower(event.InvolvedObject.Kind), event.InvolvedObject.Name, strings.TrimSpace(event.Message)))
+	}
+	return lines, nil
+}
+
+// diagnose collects what is needed to understand a failed rollout without opening kubectl.
+func (k *k8s) diagnose(name string, kind string) *Diagnostics {
+	d := &Diagnostics{Kind: kind, Name: name}
+
+	pods, owners, err := k.newPods(name, kind)
+	if err != nil {
+		d.Errors = append(d.Errors, err.Error())
+		return d
+	}
+
+	objects := owners
+	for i := range pods {
+		pod := &pods[i]
+		objects = append(objects, pod)
+		problems, failing := podProblems(pod)
+		if len(problems) == 0 {
+			continue
+		}
+		p := PodDiagnostics{
+			Name:     pod.Name,
+			Phase:    string(pod.Status.Phase),
+			Problems: problems,
+			Logs:     make(map[string]string),
+		}
+		for _, status := range failing {
+			p.Logs[status.Name] = k.containerLogs(pod, status)
+		}
+		d.Pods = append(d.Pods, p)
+	}
+
+	d.Events, err = k.recentEvents(objects)
+	if err != nil {
+		d.Errors = append(d.Errors, err.Error())
+	}
+	return d
+}
+
+func (d *Diagnostics) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Diagnostics for %s %s\n", d.Kind, d.Name)
+	if len(d.Pods) == 0 {
+		b.WriteString("  Pods: no failing pods found\n")
+	}
+	for _, pod := range d.Pods {
+		fmt.Fprintf(&b, "  Pod %s (%s)\n", pod.Name, pod.Phase)
+		for _, problem := range pod.Problems {
+			fmt.Fprintf(&b, "    - %s\n", problem)
+		}
+		containers := make([]string, 0, len(pod.Logs))
+		for container := range pod.Logs {
+			containers = append(containers, container)
+		}
+		sort.Strings(containers)
+		for _, container := range containers {
+			fmt.Fprintf(&b, "    Logs of container %s:\n", container)
+			for _, line := range strings.Split(pod.Logs[container], "\n") {
+				fmt.Fprintf(&b, "      %s\n", line)
+			}
+		}
+	}
+	if len(d.Events) > 0 {
+		b.WriteString("  Events:\n")
+		for _, event := range d.Events {
+			fmt.Fprintf(&b, "    %s\n", event)
+		}
+	}
+	for _, err := range d.Errors {
+		fmt.Fprintf(&b, "  Error: %s\n", err)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
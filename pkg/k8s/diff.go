@@ -0,0 +1,432 @@
+package k8s
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	yml "gopkg.in/yaml.v2"
+	"io"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/restmapper"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	colorReset = "\x1b[0m"
+	colorBold  = "\x1b[1m"
+	colorRed   = "\x1b[31m"
+	colorGreen = "\x1b[32m"
+	colorCyan  = "\x1b[36m"
+
+	// secretMask replaces Secret values in a diff, which usually ends up in CI logs
+	secretMask = "***"
+)
+
+// colorCodes removes the colors from a diff that isn't written to a terminal.
+var colorCodes = strings.NewReplacer(colorReset, "", colorBold, "", colorRed, "", colorGreen, "", colorCyan, "")
+
+// serverFields are set by the API server and never written in a manifest.
+var serverFields = [][]string{
+	{"status"},
+	{"metadata", "creationTimestamp"},
+	{"metadata", "resourceVersion"},
+	{"metadata", "uid"},
+	{"metadata", "selfLink"},
+	{"metadata", "generation"},
+}
+
+func (k *k8s) restMapping(gvk schema.GroupVersionKind) (*meta.RESTMapping, error) {
+	k.mapperOnce.Do(func() {
+		var resources []*restmapper.APIGroupResources
+		resources, k.mapperErr = restmapper.GetAPIGroupResources(k.client.Discovery())
+		k.mapper = restmapper.NewDiscoveryRESTMapper(resources)
+	})
+	if k.mapperErr != nil {
+		return nil, k.mapperErr
+	}
+	return k.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+}
+
+// resetRESTMapper drops the cached API resources, so that the kinds of newly created CRDs are found.
+func (k *k8s) resetRESTMapper() {
+	k.mapperOnce = sync.Once{}
+	k.mapper = nil
+	k.mapperErr = nil
+}
+
+// resourceClient returns the dynamic client for an object, in the object's namespace or -namespace.
+func (k *k8s) resourceClient(obj *unstructured.Unstructured) (dynamic.ResourceInterface, error) {
+	mapping, err := k.restMapping(obj.GroupVersionKind())
+	if err != nil {
+		return nil, err
+	}
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		return k.dynamic.Resource(mapping.Resource), nil
+	}
+	namespace := obj.GetNamespace()
+	if namespace == "" {
+		namespace = k.namespace
+	}
+	return k.dynamic.Resource(mapping.Resource).Namespace(namespace), nil
+}
+
+// desiredObject returns a processed object as it is sent to the cluster, without server
+// populated fields and in -namespace when the manifest doesn't set one.
+func (k *k8s) desiredObject(object lintObject) (*unstructured.Unstructured, dynamic.ResourceInterface, error) {
+	desired, err := toUnstructured(object.obj)
+	if err != nil {
+		return nil, nil, err
+	}
+	mapping, err := k.restMapping(desired.GroupVersionKind())
+	if err != nil {
+		return desired, nil, err
+	}
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace && desired.GetNamespace() == "" {
+		desired.SetNamespace(k.namespace)
+	}
+	removeServerFields(desired.Object)
+	client, err := k.resourceClient(desired)
+	return desired, client, err
+}
+
+func toUnstructured(obj runtime.Object) (*unstructured.Unstructured, error) {
+	if u, ok := obj.(*unstructured.Unstructured); ok {
+		return u.DeepCopy(), nil
+	}
+	data, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	return &unstructured.Unstructured{Object: data}, nil
+}
+
+func objectName(obj *unstructured.Unstructured) string {
+	name := strings.ToLower(obj.GetKind()) + "/" + obj.GetName()
+	if obj.GetNamespace() != "" {
+		name = obj.GetNamespace() + "/" + name
+	}
+	return name
+}
+
+// removeServerFields drops the fields the API server populates, including the
+// creation timestamps of pod templates that updateTimestamp sets.
+func removeServerFields(obj map[string]interface{}) {
+	for _, field := range serverFields {
+		unstructured.RemoveNestedField(obj, field...)
+	}
+	removeTemplateTimestamps(obj)
+}
+
+func removeTemplateTimestamps(value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if metadata, ok := v["metadata"].(map[string]interface{}); ok {
+			delete(metadata, "creationTimestamp")
+		}
+		for _, child := range v {
+			removeTemplateTimestamps(child)
+		}
+	case []interface{}:
+		for _, child := range v {
+			removeTemplateTimestamps(child)
+		}
+	}
+}
+
+// pruneDefaults keeps only the fields of live that are set in desired, so that defaults
+// filled in by the API server don't show up as changes. Lists are pruned item by item.
+func pruneDefaults(live interface{}, desired interface{}) interface{} {
+	switch d := desired.(type) {
+	case map[string]interface{}:
+		l, ok := live.(map[string]interface{})
+		if !ok {
+			return live
+		}
+		pruned := make(map[string]interface{})
+		for key, value := range l {
+			if desiredValue, ok := d[key]; ok {
+				pruned[key] = pruneDefaults(value, desiredValue)
+			}
+		}
+		return pruned
+	case []interface{}:
+		l, ok := live.([]interface{})
+		if !ok {
+			return live
+		}
+		pruned := make([]interface{}, len(l))
+		for i, value := range l {
+			if i < len(d) {
+				pruned[i] = pruneDefaults(value, d[i])
+			} else {
+				pruned[i] = value
+			}
+		}
+		return pruned
+	}
+	return live
+}
+
+func marshalObject(obj map[string]interface{}) ([]string, error) {
+	if obj == nil {
+		return nil, nil
+	}
+	data, err := yml.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	// yaml output ends with a newline, so the last element is always empty
+	lines := strings.SplitAfter(string(data), "\n")
+	return lines[:len(lines)-1], nil
+}
+
+// diffObject returns the normalized live and desired YAML of an object. Live is nil
+// when the object doesn't exist in the cluster yet.
+func (k *k8s) diffObject(object lintObject) ([]string, []string, *unstructured.Unstructured, error) {
+	desired, client, err := k.desiredObject(object)
+	if err != nil {
+		return nil, nil, desired, err
+	}
+
+	desiredObject := desired.DeepCopy().Object
+	mergeStringData(desiredObject)
+	var liveObject map[string]interface{}
+	live, err := client.Get(context.TODO(), desired.GetName(), metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, nil, desired, err
+	}
+	if err == nil {
+		removeServerFields(live.Object)
+		liveObject = pruneDefaults(live.Object, desiredObject).(map[string]interface{})
+	}
+	maskSecretData(liveObject, desiredObject)
+
+	before, err := marshalObject(liveObject)
+	if err != nil {
+		return nil, nil, desired, err
+	}
+	after, err := marshalObject(desiredObject)
+	return before, after, desired, err
+}
+
+func isSecret(obj map[string]interface{}) bool {
+	return obj["apiVersion"] == "v1" && obj["kind"] == "Secret"
+}
+
+// mergeStringData moves the stringData of a Secret into its data, the way the API server
+// stores it, so that it is compared with the live data.
+func mergeStringData(obj map[string]interface{}) {
+	stringData, ok := obj["stringData"].(map[string]interface{})
+	if !isSecret(obj) || !ok {
+		return
+	}
+	data, ok := obj["data"].(map[string]interface{})
+	if !ok {
+		data = make(map[string]interface{})
+		obj["data"] = data
+	}
+	for key, value := range stringData {
+		text, _ := value.(string)
+		data[key] = base64.StdEncoding.EncodeToString([]byte(text))
+	}
+	delete(obj, "stringData")
+}
+
+// maskSecretData replaces the data values of a Secret with secretMask, marked with
+// (before) and (after) when the value changes. live is nil when the Secret doesn't exist.
+func maskSecretData(live map[string]interface{}, desired map[string]interface{}) {
+	if !isSecret(desired) {
+		return
+	}
+	liveData, _ := live["data"].(map[string]interface{})
+	desiredData, _ := desired["data"].(map[string]interface{})
+	for key, value := range desiredData {
+		liveValue, ok := liveData[key]
+		switch {
+		case !ok:
+			desiredData[key] = secretMask
+		case liveValue == value:
+			liveData[key] = secretMask
+			desiredData[key] = secretMask
+		default:
+			liveData[key] = secretMask + " (before)"
+			desiredData[key] = secretMask + " (after)"
+		}
+	}
+	for key := range liveData {
+		if _, ok := desiredData[key]; !ok {
+			liveData[key] = secretMask
+		}
+	}
+}
+
+// isTerminal tells whether w is a terminal, the diff is colored only then.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Diff compares every object processed by PrepareResources with its live version and writes
+// a unified YAML diff for each object that would change, colored when w is a terminal.
+// It returns the number of changed objects.
+func (k *k8s) Diff(w io.Writer) (int, error) {
+	k.objectsMu.Lock()
+	objects := append([]lintObject{}, k.objects...)
+	k.objectsMu.Unlock()
+	sort.SliceStable(objects, func(i, j int) bool {
+		return objects[i].path < objects[j].path
+	})
+
+	color := isTerminal(w)
+	changed := 0
+	for _, object := range objects {
+		before, after, obj, err := k.diffObject(object)
+		if err != nil {
+			name := object.path
+			if obj != nil {
+				name = objectName(obj)
+			}
+			return changed, fmt.Errorf("can't diff %s: %s", name, err)
+		}
+		hunks := unifiedDiff(before, after, 3)
+		if len(hunks) == 0 {
+			k.Log().Debugf("%s is up to date", objectName(obj))
+			continue
+		}
+		changed++
+		from := "live/" + objectName(obj)
+		if before == nil {
+			from = "/dev/null"
+		}
+		var out strings.Builder
+		fmt.Fprintf(&out, "%s--- %s\n+++ %s%s\n", colorBold, from, object.path, colorReset)
+		for _, hunk := range hunks {
+			out.WriteString(hunk)
+		}
+		text := out.String()
+		if !color {
+			text = colorCodes.Replace(text)
+		}
+		fmt.Fprint(w, text)
+	}
+	return changed, nil
+}
+
+type diffLine struct {
+	op   byte
+	text string
+}
+
+// unifiedDiff returns the hunks of a unified diff between two lists of lines, each
+// line ending with a newline. It uses a longest common subsequence table, which is
+// fine for manifests of a few hundred lines.
+func unifiedDiff(a []string, b []string, context int) []string {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var lines []diffLine
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, diffLine{' ', a[i]})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, diffLine{'-', a[i]})
+			i++
+		default:
+			lines = append(lines, diffLine{'+', b[j]})
+			j++
+		}
+	}
+
+	var hunks []string
+	for start := 0; start < len(lines); {
+		if lines[start].op == ' ' {
+			start++
+			continue
+		}
+		// extend the hunk while changes are closer than two contexts apart
+		first := start - context
+		if first < 0 {
+			first = 0
+		}
+		end := start
+		for n := start; n < len(lines); n++ {
+			if lines[n].op != ' ' {
+				end = n
+			} else if n-end > 2*context {
+				break
+			}
+		}
+		last := end + context
+		if last >= len(lines) {
+			last = len(lines) - 1
+		}
+		hunks = append(hunks, formatHunk(lines, first, last))
+		start = last + 1
+	}
+	return hunks
+}
+
+func formatHunk(lines []diffLine, first int, last int) string {
+	aStart, bStart := 1, 1
+	for _, line := range lines[:first] {
+		if line.op != '+' {
+			aStart++
+		}
+		if line.op != '-' {
+			bStart++
+		}
+	}
+	aLen, bLen := 0, 0
+	var body strings.Builder
+	for _, line := range lines[first : last+1] {
+		switch line.op {
+		case '-':
+			aLen++
+			fmt.Fprintf(&body, "%s-%s%s\n", colorRed, strings.TrimSuffix(line.text, "\n"), colorReset)
+		case '+':
+			bLen++
+			fmt.Fprintf(&body, "%s+%s%s\n", colorGreen, strings.TrimSuffix(line.text, "\n"), colorReset)
+		default:
+			aLen++
+			bLen++
+			fmt.Fprintf(&body, " %s", line.text)
+		}
+	}
+	if aLen == 0 {
+		aStart--
+	}
+	if bLen == 0 {
+		bStart--
+	}
+	return fmt.Sprintf("%s@@ -%d,%d +%d,%d @@%s\n%s", colorCyan, aStart, aLen, bStart, bLen, colorReset, body.String())
+}
@@ -0,0 +1,235 @@
+package k8s
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// lines splits text ending with a newline the way marshalObject does.
+func lines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	l := strings.SplitAfter(s, "\n")
+	return l[:len(l)-1]
+}
+
+func stripColors(s string) string {
+	return colorCodes.Replace(s)
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name    string
+		a       string
+		b       string
+		context int
+		want    []string
+	}{
+		{
+			name:    "identical",
+			a:       "a\nb\nc\n",
+			b:       "a\nb\nc\n",
+			context: 3,
+		},
+		{
+			name:    "new object",
+			b:       "kind: Service\nname: web\n",
+			context: 3,
+			want:    []string{"@@ -0,0 +1,2 @@\n+kind: Service\n+name: web\n"},
+		},
+		{
+			name:    "deleted lines",
+			a:       "a\nb\nc\n",
+			context: 3,
+			want:    []string{"@@ -1,3 +0,0 @@\n-a\n-b\n-c\n"},
+		},
+		{
+			name:    "changed line with context",
+			a:       "1\n2\n3\n4\n5\n6\n7\n",
+			b:       "1\n2\n3\nfour\n5\n6\n7\n",
+			context: 1,
+			want:    []string{"@@ -3,3 +3,3 @@\n 3\n-4\n+four\n 5\n"},
+		},
+		{
+			name:    "added line",
+			a:       "1\n2\n3\n",
+			b:       "1\n2\nnew\n3\n",
+			context: 1,
+			want:    []string{"@@ -2,2 +2,3 @@\n 2\n+new\n 3\n"},
+		},
+		{
+			name:    "distant changes make separate hunks",
+			a:       "1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			b:       "one\n2\n3\n4\n5\n6\n7\n8\nnine\n",
+			context: 1,
+			want: []string{
+				"@@ -1,2 +1,2 @@\n-1\n+one\n 2\n",
+				"@@ -8,2 +8,2 @@\n 8\n-9\n+nine\n",
+			},
+		},
+		{
+			name:    "close changes share a hunk",
+			a:       "1\n2\n3\n4\n5\n",
+			b:       "one\n2\n3\nfour\n5\n",
+			context: 1,
+			want:    []string{"@@ -1,5 +1,5 @@\n-1\n+one\n 2\n 3\n-4\n+four\n 5\n"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, hunk := range unifiedDiff(lines(tt.a), lines(tt.b), tt.context) {
+				got = append(got, stripColors(hunk))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnifiedDiffColors(t *testing.T) {
+	hunks := unifiedDiff([]string{"a\n"}, []string{"b\n"}, 3)
+	want := colorCyan + "@@ -1,1 +1,1 @@" + colorReset + "\n" +
+		colorRed + "-a" + colorReset + "\n" +
+		colorGreen + "+b" + colorReset + "\n"
+	if len(hunks) != 1 || hunks[0] != want {
+		t.Errorf("got %q, want %q", hunks, want)
+	}
+}
+
+func TestPruneDefaults(t *testing.T) {
+	live := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name":       "web",
+			"generation": 4,
+		},
+		"spec": map[string]interface{}{
+			"replicas":             2,
+			"revisionHistoryLimit": 10,
+			"containers": []interface{}{
+				map[string]interface{}{"name": "web", "image": "web:v1", "imagePullPolicy": "IfNotPresent"},
+				map[string]interface{}{"name": "sidecar", "image": "proxy:v1"},
+			},
+		},
+	}
+	desired := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name": "web",
+		},
+		"spec": map[string]interface{}{
+			"replicas": 3,
+			"containers": []interface{}{
+				map[string]interface{}{"name": "web", "image": "web:v2"},
+			},
+		},
+	}
+	want := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name": "web",
+		},
+		"spec": map[string]interface{}{
+			"replicas": 2,
+			"containers": []interface{}{
+				map[string]interface{}{"name": "web", "image": "web:v1"},
+				map[string]interface{}{"name": "sidecar", "image": "proxy:v1"},
+			},
+		},
+	}
+	if got := pruneDefaults(live, desired); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestMaskSecretData(t *testing.T) {
+	desired := `apiVersion: v1
+kind: Secret
+metadata:
+  name: db
+data:
+  password: bmV3
+stringData:
+  token: tok
+`
+	tests := []struct {
+		name       string
+		desired    string
+		live       string
+		wantBefore string
+		wantAfter  string
+	}{
+		{
+			name:    "changed secret",
+			desired: desired,
+			live: `apiVersion: v1
+kind: Secret
+metadata:
+  name: db
+type: Opaque
+data:
+  password: b2xk
+  token: dG9r
+`,
+			wantBefore: "apiVersion: v1\ndata:\n  password: '*** (before)'\n  token: '***'\nkind: Secret\nmetadata:\n  name: db\n",
+			wantAfter:  "apiVersion: v1\ndata:\n  password: '*** (after)'\n  token: '***'\nkind: Secret\nmetadata:\n  name: db\n",
+		},
+		{
+			name:      "new secret",
+			desired:   desired,
+			wantAfter: "apiVersion: v1\ndata:\n  password: '***'\n  token: '***'\nkind: Secret\nmetadata:\n  name: db\n",
+		},
+		{
+			name:       "config map",
+			desired:    "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: db\ndata:\n  host: db.local\n",
+			live:       "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: db\ndata:\n  host: old.local\n",
+			wantBefore: "apiVersion: v1\ndata:\n  host: old.local\nkind: ConfigMap\nmetadata:\n  name: db\n",
+			wantAfter:  "apiVersion: v1\ndata:\n  host: db.local\nkind: ConfigMap\nmetadata:\n  name: db\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			desired := unstructuredObject(t, tt.desired).Object
+			mergeStringData(desired)
+			var live map[string]interface{}
+			if tt.live != "" {
+				live = pruneDefaults(unstructuredObject(t, tt.live).Object, desired).(map[string]interface{})
+			}
+			maskSecretData(live, desired)
+
+			before, err := marshalObject(live)
+			if err != nil {
+				t.Fatal(err)
+			}
+			after, err := marshalObject(desired)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Join(before, ""); got != tt.wantBefore {
+				t.Errorf("got before\n%s\nwant\n%s", got, tt.wantBefore)
+			}
+			if got := strings.Join(after, ""); got != tt.wantAfter {
+				t.Errorf("got after\n%s\nwant\n%s", got, tt.wantAfter)
+			}
+		})
+	}
+}
+
+func TestIsTerminal(t *testing.T) {
+	if isTerminal(&strings.Builder{}) {
+		t.Error("a buffer is not a terminal")
+	}
+	f, err := ioutil.TempFile("", "diff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if isTerminal(f) {
+		t.Error("a regular file is not a terminal")
+	}
+}
@@ -0,0 +1,122 @@
+package k8s
+
+import (
+	yml "gopkg.in/yaml.v2"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultDiscovery picks up every YAML file except vendored code and generated output.
+func DefaultDiscovery() Discovery {
+	return Discovery{
+		Include: []string{"*.yml", "*.yaml"},
+		Exclude: []string{"vendor", ".git", ".deploy"},
+	}
+}
+
+// LoadDiscovery reads include and exclude globs from a YAML file.
+func LoadDiscovery(path string) (Discovery, error) {
+	discovery := Discovery{}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return discovery, err
+	}
+	err = yml.UnmarshalStrict(data, &discovery)
+	return discovery, err
+}
+
+// ExcludeDir excludes a directory given relative to the working directory. Globs are matched
+// relative to the configuration directory dir, so directories outside of it are left out.
+func (d *Discovery) ExcludeDir(dir string, path string) error {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(absDir, absPath)
+	if err != nil {
+		return err
+	}
+	if rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil
+	}
+	d.Exclude = append(d.Exclude, filepath.ToSlash(rel))
+	return nil
+}
+
+func (d Discovery) included(path string) bool {
+	for _, pattern := range d.Include {
+		if matchGlob(pattern, path) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d Discovery) excluded(path string) bool {
+	for _, pattern := range d.Exclude {
+		if matchGlob(pattern, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob matches a slash separated path relative to the search directory. A pattern
+// without a slash is matched against the base name only, "**" matches any number of directories.
+func matchGlob(pattern string, path string) bool {
+	if !strings.Contains(pattern, "/") {
+		ok, _ := filepath.Match(pattern, filepath.Base(path))
+		return ok
+	}
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(path, "/"))
+}
+
+func matchSegments(pattern []string, path []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(path); i++ {
+				if matchSegments(pattern[1:], path[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(path) == 0 {
+			return false
+		}
+		if ok, _ := filepath.Match(pattern[0], path[0]); !ok {
+			return false
+		}
+		pattern, path = pattern[1:], path[1:]
+	}
+	return len(path) == 0
+}
+
+// manifestKinds returns the lowercased kind of every document in a file. It reports
+// false for files that are not kubernetes manifests, like CI configs or alert rules.
+func manifestKinds(data []byte) ([]string, bool) {
+	documents, err := splitDocuments(data)
+	if err != nil || len(documents) == 0 {
+		return nil, false
+	}
+	var kinds []string
+	for _, document := range documents {
+		header := struct {
+			APIVersion string `yaml:"apiVersion"`
+			Kind       string `yaml:"kind"`
+		}{}
+		if err := yml.Unmarshal(document, &header); err != nil {
+			return nil, false
+		}
+		if header.APIVersion == "" || header.Kind == "" {
+			return nil, false
+		}
+		kinds = append(kinds, strings.ToLower(header.Kind))
+	}
+	return kinds, true
+}
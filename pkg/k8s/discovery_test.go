@@ -0,0 +1,147 @@
+package k8s
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"*.yaml", "deployment.yaml", true},
+		{"*.yaml", "apps/web/deployment.yaml", true},
+		{"*.yaml", "deployment.yml", false},
+		{"vendor", "vendor", true},
+		{"vendor", "apps/vendor", true},
+		{"vendor", "vendors", false},
+		{"apps/*.yaml", "apps/web.yaml", true},
+		{"apps/*.yaml", "apps/web/deployment.yaml", false},
+		{"apps/*.yaml", "other/apps/web.yaml", false},
+		{"apps/**/*.yaml", "apps/web.yaml", true},
+		{"apps/**/*.yaml", "apps/web/deployment.yaml", true},
+		{"apps/**/*.yaml", "apps/web/v1/deployment.yaml", true},
+		{"apps/**", "apps/web/deployment.yaml", true},
+		{"**/secrets/*.yaml", "secrets/db.yaml", true},
+		{"**/secrets/*.yaml", "apps/web/secrets/db.yaml", true},
+		{"**/secrets/*.yaml", "apps/web/db.yaml", false},
+		{"deploy/monitoring", "deploy/monitoring", true},
+		{"deploy/monitoring", "monitoring", false},
+		{"deploy/monitoring", "deploy/monitoring/rules.yml", false},
+	}
+
+	for _, tt := range tests {
+		if got := matchGlob(tt.pattern, tt.path); got != tt.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestDiscovery(t *testing.T) {
+	discovery := Discovery{
+		Include: []string{"*.yaml", "*.yml"},
+		Exclude: []string{"vendor", "apps/**/test-*.yaml", "values.yaml"},
+	}
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"deployment.yaml", true},
+		{"apps/web/service.yml", true},
+		{"README.md", false},
+		{"vendor/lib/deployment.yaml", false},
+		{"apps/web/test-job.yaml", false},
+		{"test-job.yaml", true},
+		{"charts/web/values.yaml", false},
+	}
+
+	for _, tt := range tests {
+		// findResources skips excluded directories, so an excluded parent excludes the path
+		got := discovery.included(tt.path) && !discovery.excluded(tt.path)
+		for dir := filepath.Dir(tt.path); dir != "."; dir = filepath.Dir(dir) {
+			if discovery.excluded(filepath.ToSlash(dir)) {
+				got = false
+			}
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestExcludeDir(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		dir  string
+		path string
+		want []string
+	}{
+		{"same directory", ".", "monitoring", []string{"monitoring"}},
+		{"nested -dir", "deploy", "deploy/monitoring", []string{"monitoring"}},
+		{"nested monitoring", ".", "deploy/monitoring/", []string{"deploy/monitoring"}},
+		{"absolute path", "deploy", filepath.Join(wd, "deploy", "monitoring"), []string{"monitoring"}},
+		{"outside of -dir", "deploy", "monitoring", nil},
+		{"-dir itself", "deploy", "deploy", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			discovery := Discovery{}
+			if err := discovery.ExcludeDir(tt.dir, tt.path); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(discovery.Exclude, tt.want) {
+				t.Errorf("got %q, want %q", discovery.Exclude, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindResourcesExclude(t *testing.T) {
+	root, err := ioutil.TempDir("", "discovery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	manifest := []byte("apiVersion: v1\nkind: Service\nmetadata:\n  name: web\n")
+	files := map[string][]byte{
+		"deploy/service.yaml":            manifest,
+		"deploy/monitoring/rules.yaml":   manifest,
+		"deploy/vendor/lib/service.yaml": manifest,
+		"deploy/ci.yaml":                 []byte("stages:\n- build\n"),
+		"monitoring/service.yaml":        manifest,
+	}
+	for path, data := range files {
+		path = filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dir := filepath.Join(root, "deploy")
+	k := newTestK8s()
+	k.discovery = DefaultDiscovery()
+	if err := k.discovery.ExcludeDir(dir, filepath.Join(dir, "monitoring")); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, res := range k.findResources(dir, false) {
+		got = append(got, res.path)
+	}
+	want := []string{filepath.Join(dir, "service.yaml")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
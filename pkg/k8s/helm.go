@@ -0,0 +1,125 @@
+package k8s
+
+import (
+	"bytes"
+	"fmt"
+	yml "gopkg.in/yaml.v2"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// chartValues returns the values file of a chart for the datacenter, values-<DATACENTER>.yaml
+// or values/<DATACENTER>.yaml. It is empty when the chart only has its default values.
+func chartValues(chartDir string, datacenter string) string {
+	if datacenter == "" {
+		return ""
+	}
+	for _, path := range []string{
+		filepath.Join(chartDir, "values-"+datacenter+".yaml"),
+		filepath.Join(chartDir, "values", datacenter+".yaml"),
+	} {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// renderChart renders a Helm chart with `helm template` and returns one resource file per
+// chart template under renderedRoot, so that processed objects point back to their template.
+func (k *k8s) renderChart(chartDir string) ([]resourcesFile, error) {
+	args := []string{"template", filepath.Base(chartDir), chartDir}
+	if k.namespace != "" {
+		args = append(args, "--namespace", k.namespace)
+	}
+	if values := chartValues(chartDir, os.Getenv("DATACENTER")); values != "" {
+		k.Log().Infof("Rendering chart %s with values %s", chartDir, values)
+		args = append(args, "--values", values)
+	} else {
+		k.Log().Infof("Rendering chart %s with default values", chartDir)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(helmBinary, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s %s: %s: %s", helmBinary, strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return k.chartResources(chartDir, stdout.Bytes())
+}
+
+// chartResources groups the documents of `helm template` output by chart template. Test hooks
+// are skipped, they only run with `helm test`.
+func (k *k8s) chartResources(chartDir string, output []byte) ([]resourcesFile, error) {
+	documents, err := splitDocuments(output)
+	if err != nil {
+		return nil, err
+	}
+
+	var sources []string
+	bySource := make(map[string][][]byte)
+	for _, document := range documents {
+		source := templateSource(document)
+		if isTestHook(document) {
+			k.Log().Debugf("Skipping template %s of chart %s, it is a test hook", source, chartDir)
+			continue
+		}
+		if _, ok := bySource[source]; !ok {
+			sources = append(sources, source)
+		}
+		bySource[source] = append(bySource[source], document)
+	}
+
+	var resources []resourcesFile
+	for _, source := range sources {
+		data := joinDocuments(bySource[source])
+		kinds, ok := manifestKinds(data)
+		if !ok {
+			k.Log().Debugf("Skipping template %s of chart %s, it is not a kubernetes manifest", source, chartDir)
+			continue
+		}
+		resources = append(resources, resourcesFile{
+			path:         filepath.Join(k.renderedRoot(chartDir), source),
+			data:         data,
+			resourceType: kinds[0],
+		})
+	}
+	return resources, nil
+}
+
+// isTestHook tells whether a rendered document is a `helm test` hook.
+func isTestHook(document []byte) bool {
+	header := struct {
+		Metadata struct {
+			Annotations map[string]string `yaml:"annotations"`
+		} `yaml:"metadata"`
+	}{}
+	if err := yml.Unmarshal(document, &header); err != nil {
+		return false
+	}
+	for _, hook := range strings.Split(header.Metadata.Annotations[helmHookAnnotation], ",") {
+		switch strings.TrimSpace(hook) {
+		case "test", "test-success", "test-failure":
+			return true
+		}
+	}
+	return false
+}
+
+// templateSource returns the template path helm writes in the "# Source: <chart>/<path>" comment, without the chart name.
+func templateSource(document []byte) string {
+	for _, line := range strings.Split(string(document), "\n") {
+		if !strings.HasPrefix(line, "# Source: ") {
+			continue
+		}
+		source := strings.TrimSpace(strings.TrimPrefix(line, "# Source: "))
+		if i := strings.Index(source, "/"); i >= 0 {
+			source = source[i+1:]
+		}
+		return filepath.FromSlash(source)
+	}
+	return "manifest.yaml"
+}
@@ -0,0 +1,118 @@
+package k8s
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestChartValues(t *testing.T) {
+	dir := writeOverlays(t, map[string]string{
+		"web/values-dc1.yaml": "replicas: 3\n",
+		"web/values/dc2.yaml": "replicas: 5\n",
+	})
+	defer os.RemoveAll(dir)
+
+	chart := filepath.Join(dir, "web")
+	tests := []struct {
+		datacenter string
+		want       string
+	}{
+		{datacenter: "dc1", want: filepath.Join(chart, "values-dc1.yaml")},
+		{datacenter: "dc2", want: filepath.Join(chart, "values", "dc2.yaml")},
+		{datacenter: "dc3"},
+		{datacenter: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.datacenter, func(t *testing.T) {
+			if got := chartValues(chart, tt.datacenter); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTemplateSource(t *testing.T) {
+	tests := []struct {
+		name     string
+		document string
+		want     string
+	}{
+		{name: "chart template", document: "---\n# Source: web/templates/deployment.yaml\nkind: Deployment\n", want: filepath.Join("templates", "deployment.yaml")},
+		{name: "subchart template", document: "# Source: web/charts/redis/templates/service.yaml\nkind: Service\n", want: filepath.Join("charts", "redis", "templates", "service.yaml")},
+		{name: "no source", document: "kind: Service\n", want: "manifest.yaml"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := templateSource([]byte(tt.document)); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsTestHook(t *testing.T) {
+	tests := []struct {
+		name string
+		hook string
+		want bool
+	}{
+		{name: "test", hook: "test", want: true},
+		{name: "helm 2 test", hook: "test-success", want: true},
+		{name: "test among other hooks", hook: "pre-install, test", want: true},
+		{name: "install hook", hook: "pre-install"},
+		{name: "no hook"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			document := "apiVersion: v1\nkind: Pod\nmetadata:\n  name: web-test\n"
+			if tt.hook != "" {
+				document += "  annotations:\n    helm.sh/hook: " + tt.hook + "\n"
+			}
+			if got := isTestHook([]byte(document)); got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChartResources(t *testing.T) {
+	output := `---
+# Source: web/templates/service.yaml
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+---
+# Source: web/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+---
+# Source: web/templates/tests/connection.yaml
+apiVersion: v1
+kind: Pod
+metadata:
+  name: web-test-connection
+  annotations:
+    helm.sh/hook: test
+---
+# Source: web/templates/NOTES.txt
+Visit the web service
+`
+	k := newTestK8s()
+	resources, err := k.chartResources("web", []byte(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, res := range resources {
+		got = append(got, filepath.Base(res.path)+" "+res.resourceType)
+	}
+	want := []string{"service.yaml service", "deployment.yaml deployment"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,192 @@
+package k8s
+
+import (
+	"encoding/json"
+	"fmt"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// podTemplate returns the pod template of a workload, nil for objects that don't run pods.
+func podTemplate(obj runtime.Object) *corev1.PodTemplateSpec {
+	switch o := obj.(type) {
+	case *appsv1.Deployment:
+		return &o.Spec.Template
+	case *appsv1.StatefulSet:
+		return &o.Spec.Template
+	case *appsv1.DaemonSet:
+		return &o.Spec.Template
+	case *batchv1.Job:
+		return &o.Spec.Template
+	case *batchv1beta1.CronJob:
+		return &o.Spec.JobTemplate.Spec.Template
+	}
+	return nil
+}
+
+func podSpec(obj runtime.Object) *corev1.PodSpec {
+	template := podTemplate(obj)
+	if template == nil {
+		return nil
+	}
+	return &template.Spec
+}
+
+// imageRepository strips the tag and digest from an image reference. A colon
+// before the last slash belongs to the registry port, not to the tag.
+func imageRepository(image string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+	return image
+}
+
+func (k *k8s) injectImage(res *resourcesFile) {
+	if k.image.Repository == "" || k.image.Tag == "" {
+		return
+	}
+	decode := scheme.Codecs.UniversalDeserializer().Decode
+	obj, _, err := decode(res.data, nil, nil)
+	if err != nil {
+		k.Log().Fatalf("Inject image Error while decoding YAML object in file %s. Err was: %s", res.path, err)
+	}
+	spec := podSpec(obj)
+	if spec == nil {
+		return
+	}
+
+	image := k.image.Repository + ":" + k.image.Tag
+	changed := false
+	for _, containers := range [][]corev1.Container{spec.InitContainers, spec.Containers} {
+		for i := range containers {
+			if imageRepository(containers[i].Image) != k.image.Repository {
+				continue
+			}
+			k.Log().Infof("File %s: setting image of container %s to %s, was %s", res.path, containers[i].Name, image, containers[i].Image)
+			containers[i].Image = image
+			atomic.AddInt32(&k.injectedImages, 1)
+			changed = true
+		}
+	}
+	if changed {
+		res.data = k.objectToBytes(obj)
+	}
+}
+
+// checkInjectedImages fails when an image is injected but no container in dir matched its repository.
+func (k *k8s) checkInjectedImages(dir string) error {
+	if k.image.Repository != "" && k.image.Tag != "" && atomic.LoadInt32(&k.injectedImages) == 0 {
+		return fmt.Errorf("no container image in %s matches repository %s", dir, k.image.Repository)
+	}
+	return nil
+}
+
+func (k *k8s) digest(image string) (string, error) {
+	k.digestsMu.Lock()
+	defer k.digestsMu.Unlock()
+	if digest, ok := k.digests[image]; ok {
+		return digest, nil
+	}
+	digest, err := k.resolver.Digest(image)
+	if err != nil {
+		return "", err
+	}
+	if k.digests == nil {
+		k.digests = make(map[string]string)
+	}
+	k.digests[image] = digest
+	return digest, nil
+}
+
+// pinDigests replaces image tags with their registry digests, so the rollout runs exactly
+// the image that was checked. The original image is kept in a pod template annotation.
+func (k *k8s) pinDigests(res *resourcesFile) {
+	if k.resolver == nil {
+		return
+	}
+	decode := scheme.Codecs.UniversalDeserializer().Decode
+	obj, _, err := decode(res.data, nil, nil)
+	if err != nil {
+		k.Log().Fatalf("Pin digests Error while decoding YAML object in file %s. Err was: %s", res.path, err)
+	}
+	template := podTemplate(obj)
+	if template == nil {
+		return
+	}
+
+	originals := make(map[string]string)
+	if value, ok := template.Annotations[OriginalImagesAnnotation]; ok {
+		if err := json.Unmarshal([]byte(value), &originals); err != nil {
+			k.Log().Fatalf("File %s: invalid %s annotation: %s", res.path, OriginalImagesAnnotation, err)
+		}
+	}
+	changed := false
+	for _, containers := range [][]corev1.Container{template.Spec.InitContainers, template.Spec.Containers} {
+		for i := range containers {
+			image := containers[i].Image
+			if strings.Contains(image, "@") {
+				continue
+			}
+			digest, err := k.digest(image)
+			if err != nil {
+				k.Log().Fatalf("File %s: can't resolve digest of image %s: %s", res.path, image, err)
+			}
+			pinned := imageRepository(image) + "@" + digest
+			k.Log().Infof("File %s: pinning image of container %s to %s", res.path, containers[i].Name, pinned)
+			containers[i].Image = pinned
+			originals[containers[i].Name] = image
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+	value, err := json.Marshal(originals)
+	if err != nil {
+		k.Log().Fatal(err)
+	}
+	if template.Annotations == nil {
+		template.Annotations = make(map[string]string)
+	}
+	template.Annotations[OriginalImagesAnnotation] = string(value)
+	res.data = k.objectToBytes(obj)
+}
+
+func (k *k8s) collectImages(obj runtime.Object) {
+	spec := podSpec(obj)
+	if spec == nil {
+		return
+	}
+	k.imagesMu.Lock()
+	defer k.imagesMu.Unlock()
+	if k.images == nil {
+		k.images = make(map[string]bool)
+	}
+	for _, containers := range [][]corev1.Container{spec.InitContainers, spec.Containers} {
+		for _, c := range containers {
+			k.images[c.Image] = true
+		}
+	}
+}
+
+// Images returns every container image referenced in the processed manifests.
+func (k *k8s) Images() []string {
+	k.imagesMu.Lock()
+	defer k.imagesMu.Unlock()
+	images := make([]string, 0, len(k.images))
+	for image := range k.images {
+		images = append(images, image)
+	}
+	sort.Strings(images)
+	return images
+}
@@ -0,0 +1,153 @@
+package k8s
+
+import (
+	"encoding/json"
+	"fmt"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"reflect"
+	"testing"
+)
+
+type testResolver map[string]string
+
+func (r testResolver) Digest(image string) (string, error) {
+	digest, ok := r[image]
+	if !ok {
+		return "", fmt.Errorf("image %s not found", image)
+	}
+	return digest, nil
+}
+
+func TestPinDigests(t *testing.T) {
+	data := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  selector:
+    matchLabels:
+      app: web
+  template:
+    metadata:
+      labels:
+        app: web
+      annotations:
+        deploy-checker/original-images: '{"sidecar":"proxy:1.0"}'
+    spec:
+      initContainers:
+      - name: a-very-long-container-name-that-would-not-fit-in-an-annotation-key-name
+        image: registry.example.com:5000/migrate:v3
+      containers:
+      - name: web
+        image: web:v2
+      - name: sidecar
+        image: proxy@sha256:ccc
+`
+	k := newTestK8s()
+	k.resolver = testResolver{
+		"registry.example.com:5000/migrate:v3": "sha256:aaa",
+		"web:v2":                               "sha256:bbb",
+	}
+	res := &resourcesFile{path: "deployment.yaml", data: []byte(data), resourceType: "deployment"}
+	k.pinDigests(res)
+
+	obj, _, err := scheme.Codecs.UniversalDeserializer().Decode(res.data, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := obj.(*appsv1.Deployment).Spec.Template
+	images := []string{template.Spec.InitContainers[0].Image, template.Spec.Containers[0].Image, template.Spec.Containers[1].Image}
+	wantImages := []string{"registry.example.com:5000/migrate@sha256:aaa", "web@sha256:bbb", "proxy@sha256:ccc"}
+	if !reflect.DeepEqual(images, wantImages) {
+		t.Errorf("got images %q, want %q", images, wantImages)
+	}
+
+	originals := make(map[string]string)
+	if err := json.Unmarshal([]byte(template.Annotations[OriginalImagesAnnotation]), &originals); err != nil {
+		t.Fatalf("invalid %s annotation: %s", OriginalImagesAnnotation, err)
+	}
+	wantOriginals := map[string]string{
+		"a-very-long-container-name-that-would-not-fit-in-an-annotation-key-name": "registry.example.com:5000/migrate:v3",
+		"web":     "web:v2",
+		"sidecar": "proxy:1.0",
+	}
+	if !reflect.DeepEqual(originals, wantOriginals) {
+		t.Errorf("got original images %v, want %v", originals, wantOriginals)
+	}
+	if len(template.Annotations) != 1 {
+		t.Errorf("got annotations %v, want only %s", template.Annotations, OriginalImagesAnnotation)
+	}
+}
+
+func TestInjectImage(t *testing.T) {
+	data := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  selector:
+    matchLabels:
+      app: web
+  template:
+    metadata:
+      labels:
+        app: web
+    spec:
+      initContainers:
+      - name: migrate
+        image: registry.example.com:5000/web:v1
+      containers:
+      - name: web
+        image: registry.example.com:5000/web@sha256:aaa
+      - name: worker
+        image: registry.example.com:5000/web-worker:v1
+      - name: proxy
+        image: web:v1
+`
+	k := newTestK8s()
+	k.image = Image{Repository: "registry.example.com:5000/web", Tag: "v2"}
+	res := &resourcesFile{path: "deployment.yaml", data: []byte(data), resourceType: "deployment"}
+	k.injectImage(res)
+
+	obj, _, err := scheme.Codecs.UniversalDeserializer().Decode(res.data, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := obj.(*appsv1.Deployment).Spec.Template.Spec
+	images := []string{spec.InitContainers[0].Image, spec.Containers[0].Image, spec.Containers[1].Image, spec.Containers[2].Image}
+	want := []string{"registry.example.com:5000/web:v2", "registry.example.com:5000/web:v2", "registry.example.com:5000/web-worker:v1", "web:v1"}
+	if !reflect.DeepEqual(images, want) {
+		t.Errorf("got images %q, want %q", images, want)
+	}
+	if k.injectedImages != 2 {
+		t.Errorf("got %d injected images, want 2", k.injectedImages)
+	}
+	if err := k.checkInjectedImages("deploy"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCheckInjectedImages(t *testing.T) {
+	tests := []struct {
+		name     string
+		image    Image
+		injected int32
+		wantErr  bool
+	}{
+		{name: "no image", image: Image{}},
+		{name: "matched", image: Image{Repository: "web", Tag: "v2"}, injected: 1},
+		{name: "no container matched", image: Image{Repository: "web", Tag: "v2"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := newTestK8s()
+			k.image = tt.image
+			k.injectedImages = tt.injected
+			err := k.checkInjectedImages("deploy")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sort"
+	"time"
+)
+
+func (k *k8s) fetchJob(name string) (runtime.Object, error) {
+	return k.client.BatchV1().Jobs(k.namespace).Get(context.TODO(), name, metav1.GetOptions{})
+}
+
+func (k *k8s) currentJob(name string) (*batchv1.Job, error) {
+	obj, err := k.jobs.current(name)
+	if err != nil {
+		return nil, err
+	}
+	return obj.(*batchv1.Job), nil
+}
+
+func (k *k8s) jobInProgress(name string) (string, bool, error) {
+	job, err := k.currentJob(name)
+	if err != nil {
+		return "", false, err
+	}
+	for _, cond := range job.Status.Conditions {
+		if cond.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch cond.Type {
+		case batchv1.JobComplete:
+			return fmt.Sprintf("job %q successfully completed", name), true, nil
+		case batchv1.JobFailed:
+			return "", false, fmt.Errorf("job %q failed: %s", name, cond.Message)
+		}
+	}
+	return fmt.Sprintf("Waiting for job %q to complete: %d active, %d succeeded and %d failed pods...", name, job.Status.Active, job.Status.Succeeded, job.Status.Failed), false, nil
+}
+
+// preDeployJobs returns the processed jobs annotated with PreDeployAnnotation, ordered by file path.
+func (k *k8s) preDeployJobs(dir string) []*batchv1.Job {
+	resources := k.findResources(k.outputPath(dir), false)
+	sort.Slice(resources, func(i, j int) bool {
+		return resources[i].path < resources[j].path
+	})
+
+	var jobs []*batchv1.Job
+	decode := scheme.Codecs.UniversalDeserializer().Decode
+	for _, res := range resources {
+		documents, err := splitDocuments(res.data)
+		if err != nil {
+			k.Log().Fatalf("Error while reading YAML documents in file %s. Err was: %s", res.path, err)
+		}
+		for _, data := range documents {
+			obj, _, err := decode(data, nil, nil)
+			if err != nil {
+				continue
+			}
+			job, ok := obj.(*batchv1.Job)
+			if !ok || job.Annotations[PreDeployAnnotation] != "true" {
+				continue
+			}
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs
+}
+
+// deleteJob deletes a job left from the previous deploy and waits until it is gone.
+func (k *k8s) deleteJob(ctx context.Context, namespace string, name string) error {
+	jobs := k.client.BatchV1().Jobs(namespace)
+	policy := metav1.DeletePropagationBackground
+	err := jobs.Delete(ctx, name, metav1.DeleteOptions{PropagationPolicy: &policy})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	for err == nil {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+		_, err = jobs.Get(ctx, name, metav1.GetOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// recreateJob replaces a job left from the previous deploy, because a job's pod template can't be updated.
+func (k *k8s) recreateJob(ctx context.Context, job *batchv1.Job) error {
+	err := k.deleteJob(ctx, k.namespace, job.Name)
+	if err != nil {
+		return err
+	}
+	job.Namespace = k.namespace
+	_, err = k.client.BatchV1().Jobs(k.namespace).Create(ctx, job, metav1.CreateOptions{})
+	return err
+}
+
+// waitPreDeployJob waits for a pre-deploy job to complete and prints diagnostics when it fails.
+func (k *k8s) waitPreDeployJob(ctx context.Context, name string) error {
+	err := k.waitRollout(ctx, name, k.jobInProgress, k.jobs)
+	if err != nil && ctx.Err() == nil {
+		k.Log().WithField("app", name).Error(k.diagnose(name, "job"))
+	}
+	return err
+}
+
+// activeJob tells whether a job hasn't completed or failed yet.
+func activeJob(job *batchv1.Job) bool {
+	for _, cond := range job.Status.Conditions {
+		if cond.Status == corev1.ConditionTrue && (cond.Type == batchv1.JobComplete || cond.Type == batchv1.JobFailed) {
+			return false
+		}
+	}
+	return true
+}
+
+// preDeployJobPlan tells how to run a pre-deploy job over its live copy, nil when there is none.
+// An active live job is waited for instead of deleted, it may be a migration that kubectl apply
+// has just started, and it is recreated afterwards only when it runs a different pod template.
+func preDeployJobPlan(live *batchv1.Job, desired *batchv1.Job) (waitLive bool, recreate bool, err error) {
+	if live == nil || !activeJob(live) {
+		return false, true, nil
+	}
+	liveObject, err := runtime.DefaultUnstructuredConverter.ToUnstructured(live)
+	if err != nil {
+		return false, false, err
+	}
+	desiredObject, err := runtime.DefaultUnstructuredConverter.ToUnstructured(desired)
+	if err != nil {
+		return false, false, err
+	}
+	changed, err := jobTemplateChanged(&unstructured.Unstructured{Object: liveObject}, &unstructured.Unstructured{Object: desiredObject})
+	return true, changed, err
+}
+
+func (k *k8s) runPreDeployJob(ctx context.Context, job *batchv1.Job) error {
+	live, err := k.client.BatchV1().Jobs(k.namespace).Get(ctx, job.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		live, err = nil, nil
+	}
+	if err != nil {
+		return err
+	}
+	waitLive, recreate, err := preDeployJobPlan(live, job)
+	if err != nil {
+		return err
+	}
+	if waitLive {
+		k.Log().Infof("Pre-deploy job %s is already running, waiting for it", job.Name)
+		err = k.waitPreDeployJob(ctx, job.Name)
+		if err != nil || !recreate {
+			return err
+		}
+	}
+	k.Log().Infof("Running pre-deploy job %s", job.Name)
+	err = k.recreateJob(ctx, job)
+	if err != nil {
+		return fmt.Errorf("failed to create job %q: %s", job.Name, err)
+	}
+	return k.waitPreDeployJob(ctx, job.Name)
+}
+
+// RunPreDeployJobs runs the jobs annotated as pre-deploy one by one and waits for each of them,
+// so that migrations finish before the app rollouts are watched.
+func (k *k8s) RunPreDeployJobs(ctx context.Context, dir string) error {
+	k.jobs.start(ctx)
+	for _, job := range k.preDeployJobs(dir) {
+		err := k.runPreDeployJob(ctx, job)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
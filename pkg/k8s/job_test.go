@@ -0,0 +1,77 @@
+package k8s
+
+import (
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+)
+
+func testJob(image string, conditions ...batchv1.JobConditionType) *batchv1.Job {
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "migrate",
+			Annotations: map[string]string{PreDeployAnnotation: "true"},
+		},
+		Spec: batchv1.JobSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					Containers:    []corev1.Container{{Name: "migrate", Image: image}},
+				},
+			},
+		},
+	}
+	for _, condition := range conditions {
+		job.Status.Conditions = append(job.Status.Conditions, batchv1.JobCondition{Type: condition, Status: corev1.ConditionTrue})
+	}
+	return job
+}
+
+func TestPreDeployJobPlan(t *testing.T) {
+	desired := testJob("migrate:v2")
+	tests := []struct {
+		name         string
+		live         *batchv1.Job
+		wantWaitLive bool
+		wantRecreate bool
+	}{
+		{
+			name:         "no live job",
+			wantRecreate: true,
+		},
+		{
+			name:         "completed job of the previous deploy",
+			live:         testJob("migrate:v1", batchv1.JobComplete),
+			wantRecreate: true,
+		},
+		{
+			name:         "failed job",
+			live:         testJob("migrate:v2", batchv1.JobFailed),
+			wantRecreate: true,
+		},
+		{
+			// kubectl apply has just started the same job, it must not be killed
+			name:         "active job with the same template",
+			live:         testJob("migrate:v2"),
+			wantWaitLive: true,
+		},
+		{
+			name:         "active job of the previous deploy",
+			live:         testJob("migrate:v1"),
+			wantWaitLive: true,
+			wantRecreate: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			waitLive, recreate, err := preDeployJobPlan(tt.live, desired)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if waitLive != tt.wantWaitLive || recreate != tt.wantRecreate {
+				t.Errorf("got wait live %t and recreate %t, want %t and %t", waitLive, recreate, tt.wantWaitLive, tt.wantRecreate)
+			}
+		})
+	}
+}
@@ -1,25 +1,36 @@
 package k8s
 
 import (
+	"context"
+	"fmt"
 	"github.com/foxdalas/deploy-checker/pkg/checker_const"
 	yml "gopkg.in/yaml.v2"
 	"io/ioutil"
 	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	autoscalingv2beta1 "k8s.io/api/autoscaling/v2beta1"
 	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
 	"k8s.io/api/core/v1"
 	"k8s.io/api/extensions/v1beta1"
+	networkingv1 "k8s.io/api/networking/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 )
 
-func New(checker checker.Checker, kubeconfig string, namespace string, development bool, parallel bool) (*k8s, error) {
+func New(checker checker.Checker, kubeconfig string, namespace string, development bool, profile DevelopmentProfile, parallel bool, wait WaitOptions, discovery Discovery) (*k8s, error) {
 	var config *rest.Config
 	var err error
 
@@ -41,14 +52,30 @@ func New(checker checker.Checker, kubeconfig string, namespace string, developme
 	}
 
 	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
 
-	return &k8s{
-		checker:     checker,
-		client:      client,
-		namespace:   namespace,
-		development: development,
-		parallel:    parallel,
-	}, err
+	k := &k8s{
+		checker:            checker,
+		client:             client,
+		dynamic:            dynamicClient,
+		namespace:          namespace,
+		development:        development,
+		developmentProfile: profile,
+		parallel:           parallel,
+		wait:               wait,
+		discovery:          discovery,
+	}
+	k.deployments = newRolloutTracker(k, "deployment", client.AppsV1().Deployments(namespace).Watch, k.fetchDeployment)
+	k.statefulsets = newRolloutTracker(k, "statefulset", client.AppsV1().StatefulSets(namespace).Watch, k.fetchStatefulset)
+	k.daemonsets = newRolloutTracker(k, "daemonset", client.AppsV1().DaemonSets(namespace).Watch, k.fetchDaemonset)
+	k.jobs = newRolloutTracker(k, "job", client.BatchV1().Jobs(namespace).Watch, k.fetchJob)
+	return k, nil
 }
 
 func (k *k8s) processingResources(resources []resourcesFile) {
@@ -66,48 +93,269 @@ func (k *k8s) processingResources(resources []resourcesFile) {
 
 func (k *k8s) processingFile(res *resourcesFile) {
 	k.Log().Debugf("Processing file %s", res.path)
+	k.substituteVariables(res)
+	documents, err := splitDocuments(res.data)
+	if err != nil {
+		k.Log().Fatalf("Error while reading YAML documents in file %s. Err was: %s", res.path, err)
+	}
+	for i, data := range documents {
+		document := &resourcesFile{
+			path:         res.path,
+			data:         data,
+			resourceType: res.resourceType,
+		}
+		//Apply DATACENTER overlays, once, processingDocument runs again on converted objects
+		k.applyOverlays(document)
+		documents[i] = k.processingDocument(document)
+	}
+	k.writeResourceFile(joinDocuments(documents), res.path)
+}
+
+func (k *k8s) processingDocument(res *resourcesFile) []byte {
+	decode := scheme.Codecs.UniversalDeserializer().Decode
+	_, gvk, err := decode(res.data, nil, nil)
+	if runtime.IsNotRegisteredError(err) {
+		return k.processingUnstructured(res)
+	}
+	if err != nil {
+		k.Log().Fatalf("Error while decoding YAML object in file %s. Err was: %s", res.path, err)
+	}
+	res.resourceType = strings.ToLower(gvk.Kind)
+
 	//Fix replicas
 	k.fixReplicas(res)
 	//Update timestamps
 	k.updateTimestamp(res)
+	//Set -repository/-tag image
+	k.injectImage(res)
+	//Pin image tags to digests
+	k.pinDigests(res)
 	//Prepare for development environment
 	if k.development {
 		k.prepareForDevelopment(res)
 	}
-	decode := scheme.Codecs.UniversalDeserializer().Decode
 	obj, _, err := decode(res.data, nil, nil)
 	if err != nil {
 		k.Log().Fatalf("Error while decoding YAML object in file %s. Err was: %s", res.path, err)
 	}
+	switch obj.(type) {
+	case *v1beta1.Deployment, *v1beta1.DaemonSet:
+		k.convertResources(res)
+		return k.processingDocument(res)
+	}
+	k.collectImages(obj)
+	k.collectObject(res.path, obj)
 	switch o := obj.(type) {
 	case *appsv1.Deployment:
-		k.writeResourceFile(k.objectToBytes(o), res.path)
-	case *v1beta1.Deployment:
-		k.convertResources(res)
-		k.processingFile(res)
+		return k.objectToBytes(o)
 	case *appsv1.StatefulSet:
-		k.writeResourceFile(k.objectToBytes(o), res.path)
+		return k.objectToBytes(o)
+	case *appsv1.DaemonSet:
+		return k.objectToBytes(o)
 	case *v1beta1.Ingress:
-		k.writeResourceFile(k.objectToBytes(o), res.path)
+		return k.objectToBytes(o)
 	case *v1.Service:
-		k.writeResourceFile(k.objectToBytes(o), res.path)
-	case *batchv1.Job: //batch here was added for testing purpose, remove this case anytime
-		k.Log().Warnf("Type Job is not supported! Skiping..", res.path)
+		return k.objectToBytes(o)
+	case *batchv1.Job:
+		return k.objectToBytes(o)
+	case *batchv1beta1.CronJob:
+		return k.objectToBytes(o)
+	case *v1.ConfigMap:
+		return k.objectToBytes(o)
+	case *v1.Secret:
+		return k.objectToBytes(o)
+	case *v1.ServiceAccount:
+		return k.objectToBytes(o)
+	case *autoscalingv1.HorizontalPodAutoscaler:
+		return k.objectToBytes(o)
+	case *autoscalingv2beta1.HorizontalPodAutoscaler:
+		return k.objectToBytes(o)
+	case *policyv1beta1.PodDisruptionBudget:
+		return k.objectToBytes(o)
+	case *networkingv1.NetworkPolicy:
+		return k.objectToBytes(o)
+	case *v1beta1.NetworkPolicy:
+		return k.objectToBytes(o)
 	default:
-		k.Log().Fatalf("File %s is not a kubernetes deployment", res.path)
+		k.Log().Warnf("Kind %s in file %s is not supported, writing it unchanged", gvk.Kind, res.path)
+		return res.data
 	}
 }
 
-func (k *k8s) PrepareResources(dir string, development bool) {
-	k.processingResources(k.findResources(dir))
+// PrepareResources processes the manifests under dir. Images are pinned to digests when resolver isn't nil
+// and ${NAME} variables are substituted when variables isn't nil.
+func (k *k8s) PrepareResources(dir string, image Image, resolver DigestResolver, variables Variables) {
+	k.image = image
+	k.resolver = resolver
+	k.variables = variables
+	overlays, err := loadOverlays(dir, os.Getenv("DATACENTER"))
+	if err != nil {
+		k.Log().Fatalf("Can't read overlays for datacenter %s: %s", os.Getenv("DATACENTER"), err)
+	}
+	k.overlays = overlays
+	kustomizeOverlay, err := loadKustomizeOverlay(dir, os.Getenv("DATACENTER"))
+	if err != nil {
+		k.Log().Fatalf("Can't read kustomize overlay for datacenter %s: %s", os.Getenv("DATACENTER"), err)
+	}
+	k.kustomizeOverlay = kustomizeOverlay
+	k.processingResources(k.findResources(dir, true))
+	for _, patch := range k.overlays {
+		if patch.applied == 0 {
+			k.Log().Warnf("Overlay %s doesn't match any manifest, %s %s not found", patch.path, patch.target.Kind, patch.target.Name)
+		}
+	}
+	if k.kustomizeOverlay != nil && !k.kustomizeOverlay.built {
+		k.Log().Warnf("Overlay %s doesn't build on any kustomization in %s", k.kustomizeOverlay.dir, dir)
+	}
+	if err := k.checkInjectedImages(dir); err != nil {
+		k.Log().Fatal(err)
+	}
+}
+
+func (k *k8s) rolloutChecker(name string) (func(string) (string, bool, error), *rolloutTracker) {
+	if k.isResourceExist(name, k.namespace, "deployment") {
+		return k.deploymentInProgress, k.deployments
+	}
+	if k.isResourceExist(name, k.namespace, "statefulset") {
+		k.Log().Debugf("Statefulset %s exist in namespace %s", name, k.namespace)
+		return k.statefulsetInProgress, k.statefulsets
+	}
+	if k.isResourceExist(name, k.namespace, "daemonset") {
+		k.Log().Debugf("Daemonset %s exist in namespace %s", name, k.namespace)
+		return k.daemonsetInProgress, k.daemonsets
+	}
+	if k.isResourceExist(name, k.namespace, "job") {
+		k.Log().Debugf("Job %s exist in namespace %s", name, k.namespace)
+		return k.jobInProgress, k.jobs
+	}
+	return k.deploymentInProgress, k.deployments
+}
+
+// rolloutTimeout returns the app's RolloutTimeoutAnnotation if it is set and valid, the global timeout otherwise.
+func (k *k8s) rolloutTimeout(name string, tracker *rolloutTracker) time.Duration {
+	obj, err := tracker.current(name)
+	if err != nil {
+		return k.wait.Timeout
+	}
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return k.wait.Timeout
+	}
+	value, ok := accessor.GetAnnotations()[RolloutTimeoutAnnotation]
+	if !ok {
+		return k.wait.Timeout
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		k.Log().Warnf("Invalid %s annotation %q on %s %s: %s. Using %s", RolloutTimeoutAnnotation, value, tracker.kind, name, err, k.wait.Timeout)
+		return k.wait.Timeout
+	}
+	return timeout
+}
+
+// stabilityWindow returns the stability window, shortened to what is left of the app's rollout
+// timeout, so that the timeout bounds the whole wait.
+func (k *k8s) stabilityWindow(name string, tracker *rolloutTracker, started time.Time) time.Duration {
+	timeout := k.rolloutTimeout(name, tracker)
+	if timeout <= 0 {
+		return k.wait.StabilityWindow
+	}
+	remaining := timeout - time.Since(started)
+	if remaining < 0 {
+		remaining = 0
+	}
+	if remaining < k.wait.StabilityWindow {
+		k.Log().Warnf("Only %s of the %s rollout timeout is left for %s %q, shortening the stability window", remaining.Round(time.Second), timeout, tracker.kind, name)
+		return remaining
+	}
+	return k.wait.StabilityWindow
 }
 
-func (k *k8s) Wait(name string, wg *sync.WaitGroup) error {
-	defer wg.Done()
+// Wait follows the rollout of a single app and reports how it ended.
+func (k *k8s) Wait(ctx context.Context, name string) (result RolloutResult) {
+	started := time.Now()
+	inProgress, tracker := k.rolloutChecker(name)
+	tracker.start(ctx)
+	result = RolloutResult{App: name, Kind: tracker.kind, Status: RolloutSucceeded}
+	// the deferred state is part of the returned result, so result must stay the named return value
+	defer func() {
+		result.Duration = time.Since(started)
+		result.Revision, result.Replicas = k.rolloutState(name, tracker.kind)
+	}()
+
+	err := k.waitRollout(ctx, name, inProgress, tracker)
+	if err == nil && k.wait.StabilityWindow > 0 && tracker != k.jobs {
+		err = k.waitStable(ctx, name, inProgress, tracker, k.stabilityWindow(name, tracker, started))
+	}
+	if err == nil {
+		return result
+	}
+	result.Err = err
+	if ctx.Err() != nil {
+		result.Status = RolloutCancelled
+		return result
+	}
+	result.Status = RolloutFailed
+	result.Diagnostics = k.diagnose(name, tracker.kind)
+	k.Log().WithField("app", name).Error(result.Diagnostics)
+	if !k.wait.RollbackOnFailure || tracker != k.deployments {
+		return result
+	}
+
+	k.Log().Warnf("Rolling back deployment %s", name)
+	rev, generation, rollbackErr := k.rollback(name)
+	if rollbackErr != nil {
+		k.Log().Errorf("Rollback of deployment %s failed: %s", name, rollbackErr)
+		return result
+	}
+	k.Log().Infof("Deployment %s rolled back to revision %d. Waiting for it to become healthy", name, rev)
+	if rollbackErr = k.waitRollout(ctx, name, k.rollbackInProgress(generation), tracker); rollbackErr != nil {
+		k.Log().Errorf("Deployment %s is not healthy after rollback: %s", name, rollbackErr)
+		return result
+	}
+	result.Status = RolloutRolledBack
+	return result
+}
+
+// rolloutState returns the revision the app runs and how many of its replicas are ready.
+func (k *k8s) rolloutState(name string, kind string) (string, int32) {
+	switch kind {
+	case "deployment":
+		if deployment, err := k.currentDeployment(name); err == nil {
+			return deployment.Annotations[RevisionAnnotation], deployment.Status.ReadyReplicas
+		}
+	case "statefulset":
+		if sts, err := k.currentStatefulset(name); err == nil {
+			return sts.Status.CurrentRevision, sts.Status.ReadyReplicas
+		}
+	case "daemonset":
+		if ds, err := k.currentDaemonset(name); err == nil {
+			return ds.Annotations[appsv1.DeprecatedTemplateGeneration], ds.Status.NumberReady
+		}
+	case "job":
+		if job, err := k.currentJob(name); err == nil {
+			return "", job.Status.Succeeded
+		}
+	}
+	return "", 0
+}
+
+func (k *k8s) waitRollout(ctx context.Context, name string, inProgress func(string) (string, bool, error), tracker *rolloutTracker) error {
 	var message string
-	ticker := 0
+	var stalled map[string]time.Time
+	updates := tracker.subscribe(name)
+	defer tracker.unsubscribe(name, updates)
+
+	timeout := k.rolloutTimeout(name, tracker)
+	if timeout > 0 {
+		k.Log().Debugf("Waiting up to %s for %s %s", timeout, tracker.kind, name)
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
 	for {
-		state, status, err := k.deploymentInProgress(name)
+		state, status, err := inProgress(name)
 		if err != nil {
 			k.Log().Error(err)
 			return err
@@ -119,21 +367,90 @@ func (k *k8s) Wait(name string, wg *sync.WaitGroup) error {
 		if status {
 			return nil
 		}
-		time.Sleep(time.Second * 5)
-		ticker++
+		interval := tracker.pollInterval()
+		if k.wait.StallThreshold > 0 {
+			stalled, err = k.checkStalled(name, tracker.kind, stalled)
+			if err != nil {
+				k.Log().Error(err)
+				return err
+			}
+			if interval > stallCheckInterval {
+				interval = stallCheckInterval
+			}
+		}
+		select {
+		case <-updates:
+		case <-time.After(interval):
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				err = fmt.Errorf("%s %q did not finish rollout within %s", tracker.kind, name, timeout)
+			} else {
+				err = fmt.Errorf("%s %q rollout wait cancelled", tracker.kind, name)
+			}
+			k.Log().Error(err)
+			return err
+		}
+	}
+}
+
+// waitStable keeps watching the new pods of a rolled out app for the window, so an
+// app that starts crash looping right after the rollout is still caught.
+func (k *k8s) waitStable(ctx context.Context, name string, inProgress func(string) (string, bool, error), tracker *rolloutTracker, window time.Duration) error {
+	k.Log().Infof("Watching %s %q for %s after rollout", tracker.kind, name, window)
+	restarts := make(map[string]int32)
+	windowEnd := time.After(window)
+	for {
+		pods, _, err := k.newPods(name, tracker.kind)
+		if err != nil {
+			return err
+		}
+		for i := range pods {
+			count := podRestarts(&pods[i])
+			if last, ok := restarts[pods[i].Name]; ok && count > last {
+				return fmt.Errorf("%s %q is not stable: pod %s restarted %d times during the stability window", tracker.kind, name, pods[i].Name, count-last)
+			}
+			restarts[pods[i].Name] = count
+		}
+		state, status, err := inProgress(name)
+		if err != nil {
+			return err
+		}
+		if !status {
+			return fmt.Errorf("%s %q is not stable: availability dropped during the stability window: %s", tracker.kind, name, state)
+		}
+
+		select {
+		case <-windowEnd:
+			k.Log().Infof("%s %q is stable", tracker.kind, name)
+			return nil
+		case <-ctx.Done():
+			return fmt.Errorf("%s %q stability wait cancelled", tracker.kind, name)
+		case <-time.After(stabilityCheckInterval):
+		}
+	}
+}
+
+func podRestarts(pod *v1.Pod) int32 {
+	var restarts int32
+	for _, status := range pod.Status.InitContainerStatuses {
+		restarts += status.RestartCount
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		restarts += status.RestartCount
 	}
+	return restarts
 }
 
 func (k *k8s) GetConfigMap(name string, namespace string) (*v1.ConfigMap, error) {
-	return k.client.CoreV1().ConfigMaps(namespace).Get(name, metav1.GetOptions{})
+	return k.client.CoreV1().ConfigMaps(namespace).Get(context.TODO(), name, metav1.GetOptions{})
 }
 
 func (k *k8s) SetConfigMap(configmap *v1.ConfigMap, namespace string) (*v1.ConfigMap, error) {
-	return k.client.CoreV1().ConfigMaps(namespace).Update(configmap)
+	return k.client.CoreV1().ConfigMaps(namespace).Update(context.TODO(), configmap, metav1.UpdateOptions{})
 }
 
 func (k *k8s) CreateConfigMap(configmap *v1.ConfigMap, namespace string) (*v1.ConfigMap, error) {
-	return k.client.CoreV1().ConfigMaps(namespace).Create(configmap)
+	return k.client.CoreV1().ConfigMaps(namespace).Create(context.TODO(), configmap, metav1.CreateOptions{})
 }
 
 func (k *k8s) GetAlerts(data string) *Alerts {
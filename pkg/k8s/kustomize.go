@@ -0,0 +1,125 @@
+package k8s
+
+import (
+	"github.com/ghodss/yaml"
+	"os"
+	"path/filepath"
+	"sigs.k8s.io/kustomize/api/krusty"
+	"sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+	"strings"
+)
+
+func isKustomization(dir string) bool {
+	_, ok := kustomizationFile(dir)
+	return ok
+}
+
+func kustomizationFile(dir string) (string, bool) {
+	for _, name := range kustomizationFiles {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// loadKustomizeOverlay returns the overlays/<datacenter>/ kustomization under dir, nil when the
+// datacenter's overlay is a directory of patches or doesn't exist.
+func loadKustomizeOverlay(dir string, datacenter string) (*kustomizeOverlay, error) {
+	if datacenter == "" {
+		return nil, nil
+	}
+	root := filepath.Join(dir, overlaysDir, datacenter)
+	if !isKustomization(root) {
+		return nil, nil
+	}
+	bases := make(map[string]bool)
+	if err := kustomizationBases(root, bases); err != nil {
+		return nil, err
+	}
+	return &kustomizeOverlay{dir: root, bases: bases}, nil
+}
+
+// kustomizationBases adds the local kustomizations that dir builds on to bases, following their
+// resources, bases and components. Files and remote targets are skipped.
+func kustomizationBases(dir string, bases map[string]bool) error {
+	path, _ := kustomizationFile(dir)
+	data, err := readFile(path)
+	if err != nil {
+		return err
+	}
+	kustomization := types.Kustomization{}
+	if err := yaml.Unmarshal(data, &kustomization); err != nil {
+		return err
+	}
+
+	var entries []string
+	entries = append(entries, kustomization.Resources...)
+	entries = append(entries, kustomization.Bases...)
+	entries = append(entries, kustomization.Components...)
+	for _, entry := range entries {
+		if !filepath.IsAbs(entry) {
+			entry = filepath.Join(dir, entry)
+		}
+		base, err := filepath.Abs(entry)
+		if err != nil {
+			return err
+		}
+		if bases[base] || !isKustomization(base) {
+			continue
+		}
+		bases[base] = true
+		if err := kustomizationBases(base, bases); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildKustomization builds a kustomization in-process. The overlays/<DATACENTER>/ kustomization
+// is built instead of the first kustomization it builds on, the others it builds on are skipped.
+// Every object is returned as <kind>_<name>.yaml under renderedRoot.
+func (k *k8s) buildKustomization(dir string) ([]resourcesFile, error) {
+	source := dir
+	if overlay := k.kustomizeOverlay; overlay != nil {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, err
+		}
+		if overlay.bases[abs] {
+			if overlay.built {
+				k.Log().Debugf("Skipping kustomization %s, it is built with overlay %s", dir, overlay.dir)
+				return nil, nil
+			}
+			overlay.built = true
+			source = overlay.dir
+		}
+	}
+	k.Log().Infof("Building kustomization %s", source)
+
+	resMap, err := krusty.MakeKustomizer(krusty.MakeDefaultOptions()).Run(filesys.MakeFsOnDisk(), source)
+	if err != nil {
+		return nil, err
+	}
+
+	var resources []resourcesFile
+	for _, resource := range resMap.Resources() {
+		document, err := resource.AsYAML()
+		if err != nil {
+			return nil, err
+		}
+		kind := strings.ToLower(resource.GetKind())
+		name := resource.GetName()
+		if namespace := resource.GetNamespace(); namespace != "" {
+			name = namespace + "_" + name
+		}
+		resources = append(resources, resourcesFile{
+			path:         filepath.Join(k.renderedRoot(dir), kind+"_"+name+".yaml"),
+			data:         document,
+			resourceType: kind,
+		})
+	}
+	return resources, nil
+}
@@ -0,0 +1,93 @@
+package k8s
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuildKustomization(t *testing.T) {
+	root, err := ioutil.TempDir("", "kustomize")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	deployment := "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: %s\nspec:\n  replicas: 1\n"
+	files := map[string]string{
+		"app/kustomization.yaml":          "resources:\n- deployment.yaml\n",
+		"app/deployment.yaml":             strings.Replace(deployment, "%s", "web", 1),
+		"worker/kustomization.yaml":       "resources:\n- deployment.yaml\n",
+		"worker/deployment.yaml":          strings.Replace(deployment, "%s", "worker", 1),
+		"overlays/dc1/kustomization.yaml": "namespace: dc1\nresources:\n- ../../app\n- ../../worker\nreplicas:\n- name: web\n  count: 3\n",
+		"overlays/dc2/service.yaml":       "apiVersion: v1\nkind: Service\nmetadata:\n  name: web\n",
+	}
+	for path, data := range files {
+		path = filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	k := newTestK8s()
+	app := k.renderedRoot(filepath.Join(root, "app"))
+	worker := k.renderedRoot(filepath.Join(root, "worker"))
+	tests := []struct {
+		name       string
+		datacenter string
+		want       []string
+		replicas   string
+	}{
+		{
+			name:       "no datacenter",
+			datacenter: "",
+			want:       []string{filepath.Join(app, "deployment_web.yaml"), filepath.Join(worker, "deployment_worker.yaml")},
+			replicas:   "replicas: 1",
+		},
+		{
+			name:       "kustomize overlay",
+			datacenter: "dc1",
+			want:       []string{filepath.Join(app, "deployment_dc1_web.yaml"), filepath.Join(app, "deployment_dc1_worker.yaml")},
+			replicas:   "replicas: 3",
+		},
+		{
+			name:       "patch overlay",
+			datacenter: "dc2",
+			want:       []string{filepath.Join(app, "deployment_web.yaml"), filepath.Join(worker, "deployment_worker.yaml")},
+			replicas:   "replicas: 1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := newTestK8s()
+			k.discovery = DefaultDiscovery()
+			overlay, err := loadKustomizeOverlay(root, tt.datacenter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			k.kustomizeOverlay = overlay
+
+			var got []string
+			for _, res := range k.findResources(root, true) {
+				got = append(got, res.path)
+				if res.resourceType != "deployment" {
+					t.Errorf("%s: got resource type %q, want deployment", res.path, res.resourceType)
+				}
+				if strings.HasSuffix(res.path, "web.yaml") && !strings.Contains(string(res.data), tt.replicas) {
+					t.Errorf("%s: want %q, got\n%s", res.path, tt.replicas, res.data)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if overlay != nil && !overlay.built {
+				t.Errorf("overlay %s wasn't built", overlay.dir)
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package k8s
+
+import (
+	"fmt"
+	yml "gopkg.in/yaml.v2"
+	"io/ioutil"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sort"
+	"strings"
+)
+
+var lintRules = []lintRule{
+	{ID: "no-latest-tag", Severity: SeverityError, Check: checkLatestTag},
+	{ID: "privileged", Severity: SeverityError, Check: checkPrivileged},
+	{ID: "resources", Severity: SeverityWarning, Check: checkResources},
+	{ID: "probes", Severity: SeverityWarning, Check: checkProbes},
+	{ID: "service-selector", Severity: SeverityError, Check: checkServiceSelector},
+}
+
+// LoadLintConfig reads disabled rules and severity overrides from a YAML file.
+func LoadLintConfig(path string) (LintConfig, error) {
+	config := LintConfig{}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	err = yml.UnmarshalStrict(data, &config)
+	if err != nil {
+		return config, err
+	}
+	for id, severity := range config.Severity {
+		if severity != SeverityError && severity != SeverityWarning {
+			return config, fmt.Errorf("rule %s has unknown severity %q", id, severity)
+		}
+	}
+	return config, nil
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("[%s] %s %s in %s: %s", v.Rule, v.Severity, v.Object, v.Path, v.Message)
+}
+
+func (k *k8s) collectObject(path string, obj runtime.Object) {
+	k.objectsMu.Lock()
+	defer k.objectsMu.Unlock()
+	k.objects = append(k.objects, lintObject{path: path, obj: obj})
+}
+
+// Lint runs the lint rules over every object processed by PrepareResources. A rule is
+// skipped when the config disables it or the object lists it in LintDisableAnnotation.
+func (k *k8s) Lint(config LintConfig) []Violation {
+	k.objectsMu.Lock()
+	objects := append([]lintObject{}, k.objects...)
+	k.objectsMu.Unlock()
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].path < objects[j].path
+	})
+
+	disabled := make(map[string]bool)
+	for _, id := range config.Disable {
+		disabled[id] = true
+	}
+
+	var violations []Violation
+	for _, rule := range lintRules {
+		if disabled[rule.ID] {
+			continue
+		}
+		severity := rule.Severity
+		if s, ok := config.Severity[rule.ID]; ok {
+			severity = s
+		}
+		for _, object := range objects {
+			accessor, err := meta.Accessor(object.obj)
+			if err != nil || annotationDisables(accessor.GetAnnotations(), rule.ID) {
+				continue
+			}
+			for _, message := range rule.Check(object, objects) {
+				violations = append(violations, Violation{
+					Rule:     rule.ID,
+					Severity: severity,
+					Path:     object.path,
+					Object:   strings.ToLower(object.obj.GetObjectKind().GroupVersionKind().Kind) + "/" + accessor.GetName(),
+					Message:  message,
+				})
+			}
+		}
+	}
+	return violations
+}
+
+func annotationDisables(annotations map[string]string, id string) bool {
+	for _, disabled := range strings.Split(annotations[LintDisableAnnotation], ",") {
+		if strings.TrimSpace(disabled) == id {
+			return true
+		}
+	}
+	return false
+}
+
+func containers(obj runtime.Object) []corev1.Container {
+	spec := podSpec(obj)
+	if spec == nil {
+		return nil
+	}
+	return append(append([]corev1.Container{}, spec.InitContainers...), spec.Containers...)
+}
+
+func checkLatestTag(object lintObject, _ []lintObject) []string {
+	var messages []string
+	for _, c := range containers(object.obj) {
+		if strings.Contains(c.Image, "@") {
+			continue
+		}
+		if c.Image == imageRepository(c.Image) || strings.HasSuffix(c.Image, ":latest") {
+			messages = append(messages, fmt.Sprintf("container %s uses image %s without a fixed tag", c.Name, c.Image))
+		}
+	}
+	return messages
+}
+
+func checkPrivileged(object lintObject, _ []lintObject) []string {
+	var messages []string
+	for _, c := range containers(object.obj) {
+		if c.SecurityContext != nil && c.SecurityContext.Privileged != nil && *c.SecurityContext.Privileged {
+			messages = append(messages, fmt.Sprintf("container %s is privileged", c.Name))
+		}
+	}
+	return messages
+}
+
+func checkResources(object lintObject, _ []lintObject) []string {
+	var messages []string
+	for _, c := range containers(object.obj) {
+		if len(c.Resources.Requests) == 0 {
+			messages = append(messages, fmt.Sprintf("container %s has no resource requests", c.Name))
+		}
+		if len(c.Resources.Limits) == 0 {
+			messages = append(messages, fmt.Sprintf("container %s has no resource limits", c.Name))
+		}
+	}
+	return messages
+}
+
+func checkProbes(object lintObject, _ []lintObject) []string {
+	kind := object.obj.GetObjectKind().GroupVersionKind().Kind
+	if kind == "Job" || kind == "CronJob" {
+		return nil
+	}
+	spec := podSpec(object.obj)
+	if spec == nil {
+		return nil
+	}
+	var messages []string
+	for _, c := range spec.Containers {
+		if c.ReadinessProbe == nil {
+			messages = append(messages, fmt.Sprintf("container %s has no readiness probe", c.Name))
+		}
+		if c.LivenessProbe == nil {
+			messages = append(messages, fmt.Sprintf("container %s has no liveness probe", c.Name))
+		}
+	}
+	return messages
+}
+
+func checkServiceSelector(object lintObject, objects []lintObject) []string {
+	service, ok := object.obj.(*corev1.Service)
+	if !ok || len(service.Spec.Selector) == 0 {
+		return nil
+	}
+	selector := labels.SelectorFromSet(service.Spec.Selector)
+	for _, other := range objects {
+		template := podTemplate(other.obj)
+		if template == nil {
+			continue
+		}
+		accessor, err := meta.Accessor(other.obj)
+		if err != nil || accessor.GetNamespace() != service.Namespace {
+			continue
+		}
+		if selector.Matches(labels.Set(template.Labels)) {
+			return nil
+		}
+	}
+	return []string{fmt.Sprintf("selector %s matches no pod template in the processed manifests", selector)}
+}
@@ -0,0 +1,413 @@
+package k8s
+
+import (
+	"io/ioutil"
+	"k8s.io/client-go/kubernetes/scheme"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// lintObjects decodes a multi-document manifest the way processingDocument does.
+func lintObjects(t *testing.T, data string) []lintObject {
+	documents, err := splitDocuments([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var objects []lintObject
+	for _, document := range documents {
+		obj, _, err := scheme.Codecs.UniversalDeserializer().Decode(document, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		objects = append(objects, lintObject{path: "manifest.yaml", obj: obj})
+	}
+	return objects
+}
+
+const lintDeployment = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: front
+spec:
+  selector:
+    matchLabels:
+      app: web
+  template:
+    metadata:
+      labels:
+        app: web
+    spec:
+      containers:
+      - name: web
+        image: web:v1
+        resources:
+          requests:
+            cpu: 100m
+          limits:
+            cpu: 200m
+        readinessProbe:
+          tcpSocket:
+            port: 80
+        livenessProbe:
+          tcpSocket:
+            port: 80
+`
+
+func TestLintRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		check func(object lintObject, objects []lintObject) []string
+		data  string
+		want  []string
+	}{
+		{
+			name:  "fixed tag",
+			check: checkLatestTag,
+			data:  lintDeployment,
+		},
+		{
+			name:  "untagged and latest images",
+			check: checkLatestTag,
+			data: `apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+spec:
+  template:
+    spec:
+      initContainers:
+      - name: init
+        image: registry.example.com:5000/init
+      containers:
+      - name: migrate
+        image: migrate:latest
+      - name: pinned
+        image: proxy@sha256:abc
+`,
+			want: []string{
+				"container init uses image registry.example.com:5000/init without a fixed tag",
+				"container migrate uses image migrate:latest without a fixed tag",
+			},
+		},
+		{
+			name:  "privileged container",
+			check: checkPrivileged,
+			data: `apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: agent
+spec:
+  selector:
+    matchLabels:
+      app: agent
+  template:
+    metadata:
+      labels:
+        app: agent
+    spec:
+      containers:
+      - name: agent
+        image: agent:v1
+        securityContext:
+          privileged: true
+      - name: exporter
+        image: exporter:v1
+        securityContext:
+          privileged: false
+`,
+			want: []string{"container agent is privileged"},
+		},
+		{
+			name:  "resources set",
+			check: checkResources,
+			data:  lintDeployment,
+		},
+		{
+			name:  "missing resources",
+			check: checkResources,
+			data: `apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: db
+spec:
+  selector:
+    matchLabels:
+      app: db
+  template:
+    metadata:
+      labels:
+        app: db
+    spec:
+      containers:
+      - name: db
+        image: postgres:12
+        resources:
+          limits:
+            memory: 1Gi
+`,
+			want: []string{"container db has no resource requests"},
+		},
+		{
+			name:  "probes set",
+			check: checkProbes,
+			data:  lintDeployment,
+		},
+		{
+			name:  "missing probes",
+			check: checkProbes,
+			data: `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: worker
+spec:
+  selector:
+    matchLabels:
+      app: worker
+  template:
+    metadata:
+      labels:
+        app: worker
+    spec:
+      containers:
+      - name: worker
+        image: worker:v1
+        livenessProbe:
+          exec:
+            command: ["true"]
+`,
+			want: []string{"container worker has no readiness probe"},
+		},
+		{
+			name:  "jobs don't need probes",
+			check: checkProbes,
+			data: `apiVersion: batch/v1beta1
+kind: CronJob
+metadata:
+  name: cleanup
+spec:
+  schedule: "@daily"
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+          - name: cleanup
+            image: cleanup:v1
+`,
+		},
+		{
+			name:  "service selects a pod template",
+			check: checkServiceSelector,
+			data: `apiVersion: v1
+kind: Service
+metadata:
+  name: web
+  namespace: front
+spec:
+  selector:
+    app: web
+---
+` + lintDeployment,
+		},
+		{
+			name:  "service selector without pods",
+			check: checkServiceSelector,
+			data: `apiVersion: v1
+kind: Service
+metadata:
+  name: api
+  namespace: front
+spec:
+  selector:
+    app: api
+---
+` + lintDeployment,
+			want: []string{"selector app=api matches no pod template in the processed manifests"},
+		},
+		{
+			name:  "service selector in another namespace",
+			check: checkServiceSelector,
+			data: `apiVersion: v1
+kind: Service
+metadata:
+  name: web
+  namespace: back
+spec:
+  selector:
+    app: web
+---
+` + lintDeployment,
+			want: []string{"selector app=web matches no pod template in the processed manifests"},
+		},
+		{
+			name:  "service without selector",
+			check: checkServiceSelector,
+			data: `apiVersion: v1
+kind: Service
+metadata:
+  name: external
+spec:
+  type: ExternalName
+  externalName: example.com
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objects := lintObjects(t, tt.data)
+			got := tt.check(objects[0], objects)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLint(t *testing.T) {
+	data := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  selector:
+    matchLabels:
+      app: web
+  template:
+    metadata:
+      labels:
+        app: web
+    spec:
+      containers:
+      - name: web
+        image: web
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: legacy
+  annotations:
+    deploy-checker/lint-disable: "resources, no-latest-tag"
+spec:
+  selector:
+    matchLabels:
+      app: legacy
+  template:
+    metadata:
+      labels:
+        app: legacy
+    spec:
+      containers:
+      - name: legacy
+        image: legacy
+`
+	tests := []struct {
+		name   string
+		config LintConfig
+		want   []string
+	}{
+		{
+			name: "default config",
+			want: []string{
+				"[no-latest-tag] error deployment/web in manifest.yaml: container web uses image web without a fixed tag",
+				"[resources] warning deployment/web in manifest.yaml: container web has no resource requests",
+				"[resources] warning deployment/web in manifest.yaml: container web has no resource limits",
+				"[probes] warning deployment/web in manifest.yaml: container web has no readiness probe",
+				"[probes] warning deployment/web in manifest.yaml: container web has no liveness probe",
+				"[probes] warning deployment/legacy in manifest.yaml: container legacy has no readiness probe",
+				"[probes] warning deployment/legacy in manifest.yaml: container legacy has no liveness probe",
+			},
+		},
+		{
+			name: "disabled rules and severity overrides",
+			config: LintConfig{
+				Disable:  []string{"probes", "resources"},
+				Severity: map[string]Severity{"no-latest-tag": SeverityWarning},
+			},
+			want: []string{
+				"[no-latest-tag] warning deployment/web in manifest.yaml: container web uses image web without a fixed tag",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := newTestK8s()
+			k.objects = lintObjects(t, data)
+			var got []string
+			for _, v := range k.Lint(tt.config) {
+				got = append(got, v.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadLintConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name string
+		data string
+		want LintConfig
+		err  bool
+	}{
+		{
+			name: "disable and severity",
+			data: "disable:\n- probes\nseverity:\n  resources: error\n  no-latest-tag: warning\n",
+			want: LintConfig{
+				Disable:  []string{"probes"},
+				Severity: map[string]Severity{"resources": SeverityError, "no-latest-tag": SeverityWarning},
+			},
+		},
+		{
+			name: "empty file",
+			data: "",
+		},
+		{
+			name: "unknown severity",
+			data: "severity:\n  probes: fatal\n",
+			err:  true,
+		},
+		{
+			name: "unknown field",
+			data: "disabled:\n- probes\n",
+			err:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "lint.yaml")
+			if err := ioutil.WriteFile(path, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := LoadLintConfig(path)
+			if tt.err {
+				if err == nil {
+					t.Errorf("got %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := LoadLintConfig(filepath.Join(dir, "missing.yaml")); err == nil {
+		t.Error("missing lint config didn't return an error")
+	}
+}
@@ -0,0 +1,168 @@
+package k8s
+
+import (
+	"encoding/json"
+	"fmt"
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/ghodss/yaml"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes/scheme"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+)
+
+// loadOverlays reads the patches of overlays/<datacenter>/ under dir. Documents with a target
+// and a patch list are JSON patches, any other document is a strategic merge patch.
+func loadOverlays(dir string, datacenter string) ([]*overlayPatch, error) {
+	if datacenter == "" {
+		return nil, nil
+	}
+	root := filepath.Join(dir, overlaysDir, datacenter)
+	if _, err := os.Stat(root); os.IsNotExist(err) {
+		return nil, nil
+	}
+	// kustomize builds its own overlays, see loadKustomizeOverlay
+	if isKustomization(root) {
+		return nil, nil
+	}
+
+	var patches []*overlayPatch
+	err := filepath.Walk(root, func(path string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if f.IsDir() {
+			return nil
+		}
+		switch filepath.Ext(path) {
+		case ".yml", ".yaml", ".json":
+		default:
+			return nil
+		}
+		data, err := readFile(path)
+		if err != nil {
+			return err
+		}
+		documents, err := splitDocuments(data)
+		if err != nil {
+			return fmt.Errorf("can't read patches in %s: %s", path, err)
+		}
+		for _, document := range documents {
+			patch, err := parseOverlayPatch(path, document)
+			if err != nil {
+				return fmt.Errorf("invalid patch in %s: %s", path, err)
+			}
+			patches = append(patches, patch)
+		}
+		return nil
+	})
+	sort.SliceStable(patches, func(i, j int) bool {
+		return patches[i].path < patches[j].path
+	})
+	return patches, err
+}
+
+func parseOverlayPatch(path string, document []byte) (*overlayPatch, error) {
+	data, err := utilyaml.ToJSON(document)
+	if err != nil {
+		return nil, err
+	}
+	header := struct {
+		Target *overlayTarget   `json:"target"`
+		Patch  *json.RawMessage `json:"patch"`
+	}{}
+	err = json.Unmarshal(data, &header)
+	if err != nil {
+		return nil, err
+	}
+
+	if header.Target != nil || header.Patch != nil {
+		if header.Target == nil || header.Target.Kind == "" || header.Target.Name == "" {
+			return nil, fmt.Errorf("JSON patch needs target kind and name")
+		}
+		if header.Patch == nil {
+			return nil, fmt.Errorf("JSON patch for %s/%s has no patch operations", header.Target.Kind, header.Target.Name)
+		}
+		operations, err := jsonpatch.DecodePatch(*header.Patch)
+		if err != nil {
+			return nil, err
+		}
+		return &overlayPatch{path: path, target: *header.Target, operations: operations}, nil
+	}
+
+	obj := &unstructured.Unstructured{}
+	err = obj.UnmarshalJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	if obj.GetName() == "" {
+		return nil, fmt.Errorf("strategic merge patch for %s needs metadata.name", obj.GetKind())
+	}
+	target := overlayTarget{Kind: obj.GetKind(), Name: obj.GetName(), Namespace: obj.GetNamespace()}
+	return &overlayPatch{path: path, target: target, merge: data}, nil
+}
+
+func (t overlayTarget) matches(obj *unstructured.Unstructured, namespace string) bool {
+	if t.Kind != obj.GetKind() || t.Name != obj.GetName() {
+		return false
+	}
+	if obj.GetNamespace() != "" {
+		namespace = obj.GetNamespace()
+	}
+	return t.Namespace == "" || t.Namespace == namespace
+}
+
+// apply patches a JSON document. Strategic merge falls back to a JSON merge patch for
+// kinds the typed scheme doesn't know, since their patch strategies aren't available.
+func (p *overlayPatch) apply(data []byte, dataStruct runtime.Object) ([]byte, error) {
+	if p.operations != nil {
+		return p.operations.Apply(data)
+	}
+	if dataStruct == nil {
+		return jsonpatch.MergePatch(data, p.merge)
+	}
+	return strategicpatch.StrategicMergePatch(data, p.merge, dataStruct)
+}
+
+// applyOverlays patches a document with every overlay patch targeting it, before any other transform.
+func (k *k8s) applyOverlays(res *resourcesFile) {
+	if len(k.overlays) == 0 {
+		return
+	}
+	data, err := utilyaml.ToJSON(res.data)
+	if err != nil {
+		k.Log().Fatalf("Error while decoding YAML object in file %s. Err was: %s", res.path, err)
+	}
+	obj := &unstructured.Unstructured{}
+	err = obj.UnmarshalJSON(data)
+	if err != nil {
+		k.Log().Fatalf("Error while decoding YAML object in file %s. Err was: %s", res.path, err)
+	}
+	dataStruct, _ := scheme.Scheme.New(obj.GroupVersionKind())
+
+	patched := false
+	for _, patch := range k.overlays {
+		if !patch.target.matches(obj, k.namespace) {
+			continue
+		}
+		k.Log().Debugf("Applying overlay %s to %s/%s from file %s", patch.path, obj.GetKind(), obj.GetName(), res.path)
+		data, err = patch.apply(data, dataStruct)
+		if err != nil {
+			k.Log().Fatalf("Can't apply overlay %s to %s/%s from file %s: %s", patch.path, obj.GetKind(), obj.GetName(), res.path, err)
+		}
+		atomic.AddInt32(&patch.applied, 1)
+		patched = true
+	}
+	if !patched {
+		return
+	}
+	res.data, err = yaml.JSONToYAML(data)
+	if err != nil {
+		k.Log().Fatalf("Error while encoding YAML object in file %s. Err was: %s", res.path, err)
+	}
+}
@@ -0,0 +1,265 @@
+package k8s
+
+import (
+	"io/ioutil"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseOverlayPatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		document string
+		want     overlayTarget
+		wantJSON bool
+		wantErr  bool
+	}{
+		{
+			name:     "strategic merge patch",
+			document: "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: web\n  namespace: front\nspec:\n  replicas: 5\n",
+			want:     overlayTarget{Kind: "Deployment", Name: "web", Namespace: "front"},
+		},
+		{
+			name:     "strategic merge patch without name",
+			document: "apiVersion: apps/v1\nkind: Deployment\nspec:\n  replicas: 5\n",
+			wantErr:  true,
+		},
+		{
+			name:     "JSON patch",
+			document: "target:\n  kind: Service\n  name: web\npatch:\n- op: replace\n  path: /spec/type\n  value: NodePort\n",
+			want:     overlayTarget{Kind: "Service", Name: "web"},
+			wantJSON: true,
+		},
+		{
+			name:     "JSON patch without target name",
+			document: "target:\n  kind: Service\npatch:\n- op: remove\n  path: /spec/type\n",
+			wantErr:  true,
+		},
+		{
+			name:     "JSON patch without operations",
+			document: "target:\n  kind: Service\n  name: web\n",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patch, err := parseOverlayPatch("patch.yaml", []byte(tt.document))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if patch.target != tt.want {
+				t.Errorf("got target %+v, want %+v", patch.target, tt.want)
+			}
+			if (patch.operations != nil) != tt.wantJSON {
+				t.Errorf("got JSON patch %t, want %t", patch.operations != nil, tt.wantJSON)
+			}
+		})
+	}
+}
+
+func TestOverlayTargetMatches(t *testing.T) {
+	obj := unstructuredObject(t, "apiVersion: v1\nkind: Service\nmetadata:\n  name: web\n")
+	tests := []struct {
+		name   string
+		target overlayTarget
+		want   bool
+	}{
+		{name: "kind and name", target: overlayTarget{Kind: "Service", Name: "web"}, want: true},
+		{name: "default namespace", target: overlayTarget{Kind: "Service", Name: "web", Namespace: "default"}, want: true},
+		{name: "other namespace", target: overlayTarget{Kind: "Service", Name: "web", Namespace: "front"}},
+		{name: "other name", target: overlayTarget{Kind: "Service", Name: "api"}},
+		{name: "other kind", target: overlayTarget{Kind: "Deployment", Name: "web"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.target.matches(obj, "default"); got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+// writeOverlays writes files under a temp dir and returns it.
+func writeOverlays(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "overlay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, data := range files {
+		path = filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestApplyOverlays(t *testing.T) {
+	dir := writeOverlays(t, map[string]string{
+		"overlays/dc1/web.yaml": `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      containers:
+      - name: web
+        image: web:dc1
+`,
+		"overlays/dc1/service.yaml": "target:\n  kind: Service\n  name: web\npatch:\n- op: replace\n  path: /spec/ports/0/port\n  value: 8080\n",
+		"overlays/dc1/widget.yaml":  "apiVersion: example.com/v1\nkind: Widget\nmetadata:\n  name: gear\nspec:\n  size: large\n",
+		"overlays/dc1/missing.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: missing\ndata:\n  a: b\n",
+		"overlays/dc2/web.yaml":     "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: web\nspec:\n  replicas: 9\n",
+	})
+	defer os.RemoveAll(dir)
+
+	k := newTestK8s()
+	overlays, err := loadOverlays(dir, "dc1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.overlays = overlays
+
+	tests := []struct {
+		name     string
+		document string
+		field    []string
+		want     interface{}
+	}{
+		{
+			// containers are merged by name, the sidecar is kept
+			name: "strategic merge patch",
+			document: `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      containers:
+      - name: web
+        image: web:v1
+      - name: sidecar
+        image: proxy:v1
+`,
+			field: []string{"spec", "template", "spec", "containers"},
+			want: []interface{}{
+				map[string]interface{}{"name": "web", "image": "web:dc1"},
+				map[string]interface{}{"name": "sidecar", "image": "proxy:v1"},
+			},
+		},
+		{
+			name:     "JSON patch",
+			document: "apiVersion: v1\nkind: Service\nmetadata:\n  name: web\nspec:\n  ports:\n  - port: 80\n",
+			field:    []string{"spec", "ports"},
+			want:     []interface{}{map[string]interface{}{"port": int64(8080)}},
+		},
+		{
+			name:     "merge patch of an unknown kind",
+			document: "apiVersion: example.com/v1\nkind: Widget\nmetadata:\n  name: gear\nspec:\n  size: small\n  color: red\n",
+			field:    []string{"spec"},
+			want:     map[string]interface{}{"size": "large", "color": "red"},
+		},
+		{
+			name:     "no matching patch",
+			document: "apiVersion: v1\nkind: Service\nmetadata:\n  name: api\nspec:\n  ports:\n  - port: 80\n",
+			field:    []string{"spec", "ports"},
+			want:     []interface{}{map[string]interface{}{"port": int64(80)}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := &resourcesFile{path: "manifest.yaml", data: []byte(tt.document)}
+			k.applyOverlays(res)
+			got, _, err := unstructured.NestedFieldNoCopy(unstructuredObject(t, string(res.data)).Object, tt.field...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// an unused patch is reported by PrepareResources
+	applied := make(map[string]int32)
+	for _, patch := range k.overlays {
+		applied[filepath.Base(patch.path)] = patch.applied
+	}
+	want := map[string]int32{"missing.yaml": 0, "service.yaml": 1, "web.yaml": 1, "widget.yaml": 1}
+	if !reflect.DeepEqual(applied, want) {
+		t.Errorf("got applied %v, want %v", applied, want)
+	}
+}
+
+func TestProcessingFileAppliesOverlaysOnce(t *testing.T) {
+	dir := writeOverlays(t, map[string]string{
+		"overlays/dc1/env.yaml": `target:
+  kind: Deployment
+  name: web
+patch:
+- op: add
+  path: /spec/template/spec/containers/0/env/-
+  value:
+    name: DATACENTER
+    value: dc1
+`,
+	})
+	defer os.RemoveAll(dir)
+
+	k := newTestK8s()
+	overlays, err := loadOverlays(dir, "dc1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.overlays = overlays
+
+	// extensions/v1beta1 objects are converted and processed again
+	data := `apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    metadata:
+      labels:
+        app: web
+    spec:
+      containers:
+      - name: web
+        image: web:v1
+        env:
+        - name: LOG_LEVEL
+          value: info
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: web
+data:
+  a: b
+`
+	path := filepath.Join(dir, "web.yaml")
+	k.processingFile(&resourcesFile{path: path, data: []byte(data), resourceType: "deployment"})
+	processed, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(string(processed), "name: DATACENTER"); got != 1 {
+		t.Errorf("got the patched env %d times, want once:\n%s", got, processed)
+	}
+	if overlays[0].applied != 1 {
+		t.Errorf("got the patch applied %d times, want once", overlays[0].applied)
+	}
+}
@@ -0,0 +1,115 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"strconv"
+)
+
+// rollbackSkippedAnnotations stay as they are on a rolled back deployment, every other annotation is
+// restored from the previous ReplicaSet. It is the list `kubectl rollout undo` skips.
+var rollbackSkippedAnnotations = map[string]bool{
+	"kubectl.kubernetes.io/last-applied-configuration": true,
+	RevisionAnnotation:                          true,
+	"deployment.kubernetes.io/revision-history": true,
+	"deployment.kubernetes.io/desired-replicas": true,
+	"deployment.kubernetes.io/max-replicas":     true,
+	"deprecated.deployment.rollback.to":         true,
+}
+
+func revision(obj metav1.Object) int64 {
+	rev, err := strconv.ParseInt(obj.GetAnnotations()[RevisionAnnotation], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return rev
+}
+
+func (k *k8s) replicaSets(deployment *appsv1.Deployment) ([]appsv1.ReplicaSet, error) {
+	selector, err := metav1.LabelSelectorAsSelector(deployment.Spec.Selector)
+	if err != nil {
+		return nil, err
+	}
+	list, err := k.client.AppsV1().ReplicaSets(deployment.Namespace).List(context.TODO(), metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+	var owned []appsv1.ReplicaSet
+	for i := range list.Items {
+		if metav1.IsControlledBy(&list.Items[i], deployment) {
+			owned = append(owned, list.Items[i])
+		}
+	}
+	return owned, nil
+}
+
+// rollbackAnnotations returns the annotations of a deployment rolled back to a ReplicaSet.
+func rollbackAnnotations(deployment map[string]string, previous map[string]string) map[string]string {
+	annotations := make(map[string]string)
+	for key, value := range deployment {
+		if rollbackSkippedAnnotations[key] {
+			annotations[key] = value
+		}
+	}
+	for key, value := range previous {
+		if !rollbackSkippedAnnotations[key] {
+			annotations[key] = value
+		}
+	}
+	return annotations
+}
+
+// rollback restores the pod template and annotations of the previous ReplicaSet, the same way
+// `kubectl rollout undo` does. It returns the restored revision and the generation of the update.
+func (k *k8s) rollback(name string) (int64, int64, error) {
+	deployment, err := k.client.AppsV1().Deployments(k.namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return 0, 0, err
+	}
+	if deployment.Spec.Paused {
+		return 0, 0, fmt.Errorf("deployment %q is paused, resume it before rolling back", name)
+	}
+
+	replicaSets, err := k.replicaSets(deployment)
+	if err != nil {
+		return 0, 0, err
+	}
+	current := revision(deployment)
+	var previous *appsv1.ReplicaSet
+	for i := range replicaSets {
+		rev := revision(&replicaSets[i])
+		if rev < current && (previous == nil || rev > revision(previous)) {
+			previous = &replicaSets[i]
+		}
+	}
+	if previous == nil {
+		return 0, 0, fmt.Errorf("deployment %q has no previous revision to roll back to", name)
+	}
+
+	template := previous.Spec.Template.DeepCopy()
+	delete(template.Labels, appsv1.DefaultDeploymentUniqueLabelKey)
+	deployment.Spec.Template = *template
+	deployment.Annotations = rollbackAnnotations(deployment.Annotations, previous.Annotations)
+	updated, err := k.client.AppsV1().Deployments(k.namespace).Update(context.TODO(), deployment, metav1.UpdateOptions{})
+	if err != nil {
+		return 0, 0, err
+	}
+	return revision(previous), updated.Generation, nil
+}
+
+// rollbackInProgress is deploymentInProgress for a rolled back deployment. It waits until the
+// watch delivers the rollback update, so the failed rollout's conditions aren't read again.
+func (k *k8s) rollbackInProgress(generation int64) func(string) (string, bool, error) {
+	return func(name string) (string, bool, error) {
+		deployment, err := k.currentDeployment(name)
+		if err != nil {
+			return "", false, err
+		}
+		if deployment.Generation < generation {
+			return fmt.Sprintf("Waiting for deployment %q rollback to be observed...", name), false, nil
+		}
+		return k.deploymentInProgress(name)
+	}
+}
@@ -0,0 +1,68 @@
+package k8s
+
+import (
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	"time"
+)
+
+var stallReasons = map[string]bool{
+	"ImagePullBackOff":           true,
+	"ErrImagePull":               true,
+	"CreateContainerConfigError": true,
+	"CrashLoopBackOff":           true,
+}
+
+// checkStalled fails the rollout when a container of the new pods has been stuck in a
+// terminal waiting reason for longer than the stall threshold. since holds when each
+// container was first seen stuck and is returned updated for the next check.
+func (k *k8s) checkStalled(name string, kind string, since map[string]time.Time) (map[string]time.Time, error) {
+	pods, _, err := k.newPods(name, kind)
+	if err != nil {
+		k.Log().Debugf("Stall check for %s %s skipped: %s", kind, name, err)
+		return since, nil
+	}
+	stuck, err := stalledContainers(pods, since, time.Now(), k.wait.StallThreshold)
+	if err != nil {
+		return stuck, fmt.Errorf("%s %q stalled: %s", kind, name, err)
+	}
+	return stuck, nil
+}
+
+// stalledContainers tracks the containers of pods stuck since a time in since, keyed by pod and container.
+// Kubelet flips between the stall reasons, e.g. ErrImagePull and ImagePullBackOff, and a crash looping
+// container runs briefly between its back-offs, so a container that has restarted and isn't ready keeps
+// its entry until it recovers. It fails when a container is stuck for threshold or longer.
+func stalledContainers(pods []corev1.Pod, since map[string]time.Time, now time.Time, threshold time.Duration) (map[string]time.Time, error) {
+	stuck := make(map[string]time.Time)
+	for _, pod := range pods {
+		statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+		for _, status := range statuses {
+			key := pod.Name + "/" + status.Name
+			first, ok := since[key]
+			waiting := status.State.Waiting
+			switch {
+			case waiting != nil && stallReasons[waiting.Reason]:
+			case ok && status.RestartCount > 0 && !status.Ready:
+			default:
+				continue
+			}
+			if !ok {
+				first = now
+			}
+			stuck[key] = first
+			if now.Sub(first) >= threshold {
+				return stuck, fmt.Errorf("container %s of pod %s is %s", status.Name, pod.Name, stallState(status, now.Sub(first)))
+			}
+		}
+	}
+	return stuck, nil
+}
+
+func stallState(status corev1.ContainerStatus, duration time.Duration) string {
+	duration = duration.Round(time.Second)
+	if waiting := status.State.Waiting; waiting != nil && stallReasons[waiting.Reason] {
+		return fmt.Sprintf("in %s for %s: %s", waiting.Reason, duration, waiting.Message)
+	}
+	return fmt.Sprintf("not ready after %d restarts for %s", status.RestartCount, duration)
+}
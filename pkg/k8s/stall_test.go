@@ -0,0 +1,79 @@
+package k8s
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+	"time"
+)
+
+func waitingStatus(reason string, restarts int32) corev1.ContainerStatus {
+	return corev1.ContainerStatus{
+		Name:         "web",
+		RestartCount: restarts,
+		State:        corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: reason}},
+	}
+}
+
+func runningStatus(ready bool, restarts int32) corev1.ContainerStatus {
+	return corev1.ContainerStatus{
+		Name:         "web",
+		Ready:        ready,
+		RestartCount: restarts,
+		State:        corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+	}
+}
+
+func TestStalledContainers(t *testing.T) {
+	threshold := 30 * time.Second
+	tests := []struct {
+		name string
+		// the container status seen every 10 seconds
+		steps []corev1.ContainerStatus
+		// the step failing the rollout, -1 if none
+		want int
+	}{
+		{
+			name:  "image pull reason flips",
+			steps: []corev1.ContainerStatus{waitingStatus("ErrImagePull", 0), waitingStatus("ImagePullBackOff", 0), waitingStatus("ErrImagePull", 0), waitingStatus("ImagePullBackOff", 0)},
+			want:  3,
+		},
+		{
+			name:  "crash loop runs between back-offs",
+			steps: []corev1.ContainerStatus{waitingStatus("CrashLoopBackOff", 1), runningStatus(false, 1), waitingStatus("CrashLoopBackOff", 2), runningStatus(false, 2)},
+			want:  3,
+		},
+		{
+			name:  "container recovers",
+			steps: []corev1.ContainerStatus{waitingStatus("CrashLoopBackOff", 1), runningStatus(false, 1), runningStatus(true, 1), waitingStatus("CrashLoopBackOff", 2), runningStatus(false, 2)},
+			want:  -1,
+		},
+		{
+			name:  "starting container",
+			steps: []corev1.ContainerStatus{waitingStatus("ContainerCreating", 0), runningStatus(false, 0), runningStatus(false, 0), runningStatus(true, 0)},
+			want:  -1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			var since map[string]time.Time
+			got := -1
+			for i, status := range tt.steps {
+				pods := []corev1.Pod{{
+					ObjectMeta: metav1.ObjectMeta{Name: "web-1"},
+					Status:     corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{status}},
+				}}
+				var err error
+				since, err = stalledContainers(pods, since, start.Add(time.Duration(i)*10*time.Second), threshold)
+				if err != nil {
+					got = i
+					break
+				}
+			}
+			if got != tt.want {
+				t.Errorf("stalled at step %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
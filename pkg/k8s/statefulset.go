@@ -1,12 +1,15 @@
 package k8s
 
 import (
+	"context"
+	"fmt"
 	appsv1 "k8s.io/api/apps/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
 func (k *k8s) getStatefulSet(name string, namespace string) *appsv1.StatefulSet {
-	obj, err := k.client.AppsV1().StatefulSets(namespace).Get(name, metav1.GetOptions{})
+	obj, err := k.client.AppsV1().StatefulSets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
 	if err != nil {
 		k.Log().Fatal(err)
 	}
@@ -14,9 +17,48 @@ func (k *k8s) getStatefulSet(name string, namespace string) *appsv1.StatefulSet
 }
 
 func (k *k8s) isStatefulsetExist(name string, namespace string) bool {
-	_, err := k.client.AppsV1().StatefulSets(namespace).Get(name, metav1.GetOptions{})
+	_, err := k.client.AppsV1().StatefulSets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
 	if err != nil {
 		return false
 	}
 	return true
 }
+
+func (k *k8s) fetchStatefulset(name string) (runtime.Object, error) {
+	return k.client.AppsV1().StatefulSets(k.namespace).Get(context.TODO(), name, metav1.GetOptions{})
+}
+
+func (k *k8s) currentStatefulset(name string) (*appsv1.StatefulSet, error) {
+	obj, err := k.statefulsets.current(name)
+	if err != nil {
+		return nil, err
+	}
+	return obj.(*appsv1.StatefulSet), nil
+}
+
+func (k *k8s) statefulsetInProgress(name string) (string, bool, error) {
+	sts, err := k.currentStatefulset(name)
+	if err != nil {
+		return "", false, err
+	}
+	if sts.Spec.UpdateStrategy.Type != appsv1.RollingUpdateStatefulSetStrategyType {
+		return "", false, fmt.Errorf("statefulset %q uses %s update strategy, rollout status is only available for %s", name, sts.Spec.UpdateStrategy.Type, appsv1.RollingUpdateStatefulSetStrategyType)
+	}
+	if sts.Status.ObservedGeneration == 0 || sts.Generation > sts.Status.ObservedGeneration {
+		return fmt.Sprintf("Waiting for statefulset %q spec update to be observed...", name), false, nil
+	}
+	if sts.Spec.Replicas != nil && sts.Status.ReadyReplicas < *sts.Spec.Replicas {
+		return fmt.Sprintf("Waiting for statefulset %q rollout to finish: %d of %d pods are ready...", name, sts.Status.ReadyReplicas, *sts.Spec.Replicas), false, nil
+	}
+	if rollingUpdate := sts.Spec.UpdateStrategy.RollingUpdate; rollingUpdate != nil && rollingUpdate.Partition != nil && sts.Spec.Replicas != nil {
+		partition := *rollingUpdate.Partition
+		if sts.Status.UpdatedReplicas < *sts.Spec.Replicas-partition {
+			return fmt.Sprintf("Waiting for statefulset %q partitioned rollout to finish: %d out of %d new pods have been updated...", name, sts.Status.UpdatedReplicas, *sts.Spec.Replicas-partition), false, nil
+		}
+		return fmt.Sprintf("statefulset %q partitioned rollout complete: %d new pods have been updated", name, sts.Status.UpdatedReplicas), true, nil
+	}
+	if sts.Status.UpdateRevision != sts.Status.CurrentRevision {
+		return fmt.Sprintf("Waiting for statefulset %q rolling update to complete: %d pods at revision %s...", name, sts.Status.UpdatedReplicas, sts.Status.UpdateRevision), false, nil
+	}
+	return fmt.Sprintf("statefulset %q successfully rolled out: %d pods at revision %s", name, sts.Status.CurrentReplicas, sts.Status.CurrentRevision), true, nil
+}
@@ -0,0 +1,150 @@
+package k8s
+
+import (
+	"context"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"time"
+)
+
+func newRolloutTracker(k *k8s, kind string, watchFunc func(context.Context, metav1.ListOptions) (watch.Interface, error), getFunc func(string) (runtime.Object, error)) *rolloutTracker {
+	return &rolloutTracker{
+		k:           k,
+		kind:        kind,
+		watch:       watchFunc,
+		getter:      getFunc,
+		objects:     make(map[string]runtime.Object),
+		subscribers: make(map[string][]chan struct{}),
+	}
+}
+
+// start opens the shared watch once, no matter how many apps are waiting on it.
+// The watch lives until ctx is cancelled, so it must not be a per-app context.
+func (t *rolloutTracker) start(ctx context.Context) {
+	t.once.Do(func() {
+		go t.run(ctx)
+	})
+}
+
+func (t *rolloutTracker) run(ctx context.Context) {
+	for {
+		w, err := t.watch(ctx, metav1.ListOptions{})
+		if err != nil {
+			t.k.Log().Warnf("Failed to watch %ss: %v. Falling back to polling", t.kind, err)
+		} else {
+			t.k.Log().Debugf("Watching %ss in namespace %s", t.kind, t.k.namespace)
+			t.setWatching(true)
+			if !t.consume(ctx, w) {
+				t.setWatching(false)
+				return
+			}
+			t.setWatching(false)
+			t.k.Log().Warnf("Watch for %ss dropped. Falling back to polling", t.kind)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(watchRetryInterval):
+		}
+	}
+}
+
+// consume handles events until the watch drops. It returns false when ctx was cancelled.
+func (t *rolloutTracker) consume(ctx context.Context, w watch.Interface) bool {
+	defer w.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				return true
+			}
+			t.handle(event)
+		}
+	}
+}
+
+func (t *rolloutTracker) handle(event watch.Event) {
+	if event.Type == watch.Error {
+		return
+	}
+	obj, err := meta.Accessor(event.Object)
+	if err != nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if event.Type == watch.Deleted {
+		delete(t.objects, obj.GetName())
+	} else {
+		t.objects[obj.GetName()] = event.Object
+	}
+	for _, ch := range t.subscribers[obj.GetName()] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (t *rolloutTracker) setWatching(watching bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.watching = watching
+	if !watching {
+		t.objects = make(map[string]runtime.Object)
+	}
+}
+
+// get returns the last object seen on the watch. It reports false while the
+// watch is down, so callers go to the API server instead of using stale data.
+func (t *rolloutTracker) get(name string) (runtime.Object, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.watching {
+		return nil, false
+	}
+	obj, ok := t.objects[name]
+	return obj, ok
+}
+
+// current returns the watched object, or fetches it from the API server when the watch can't provide it.
+func (t *rolloutTracker) current(name string) (runtime.Object, error) {
+	if obj, ok := t.get(name); ok {
+		return obj, nil
+	}
+	return t.getter(name)
+}
+
+func (t *rolloutTracker) subscribe(name string) chan struct{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ch := make(chan struct{}, 1)
+	t.subscribers[name] = append(t.subscribers[name], ch)
+	return ch
+}
+
+func (t *rolloutTracker) unsubscribe(name string, ch chan struct{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	subscribers := t.subscribers[name]
+	for i, c := range subscribers {
+		if c == ch {
+			t.subscribers[name] = append(subscribers[:i], subscribers[i+1:]...)
+			break
+		}
+	}
+}
+
+func (t *rolloutTracker) pollInterval() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.watching {
+		return resyncInterval
+	}
+	return pollInterval
+}
@@ -1,11 +1,21 @@
 package k8s
 
 import (
+	"context"
+	jsonpatch "github.com/evanphx/json-patch"
 	"github.com/foxdalas/deploy-checker/pkg/checker_const"
 	"github.com/sirupsen/logrus"
 	v1 "k8s.io/api/apps/v1"
 	"k8s.io/api/apps/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	"sync"
+	"time"
 )
 
 type resourcesFile struct {
@@ -18,13 +28,40 @@ type k8s struct {
 	checker checker.Checker
 	log     *logrus.Entry
 
-	client *kubernetes.Clientset
+	client  *kubernetes.Clientset
+	dynamic dynamic.Interface
+
+	mapperOnce sync.Once
+	mapper     meta.RESTMapper
+	mapperErr  error
 
 	namespace      string
 	deploymentFile string
 
 	parallel bool
 
+	wait      WaitOptions
+	discovery Discovery
+
+	image          Image
+	injectedImages int32
+
+	imagesMu sync.Mutex
+	images   map[string]bool
+
+	resolver  DigestResolver
+	digestsMu sync.Mutex
+	digests   map[string]string
+
+	objectsMu sync.Mutex
+	objects   []lintObject
+
+	overlays         []*overlayPatch
+	kustomizeOverlay *kustomizeOverlay
+	renderedDirs     []string
+
+	variables Variables
+
 	yamlResources struct {
 		deployment  *v1.Deployment
 		statefulset *v1beta1.StatefulSet
@@ -35,7 +72,190 @@ type k8s struct {
 		statefulset *v1beta1.StatefulSet
 	}
 
-	development bool
+	development        bool
+	developmentProfile DevelopmentProfile
+
+	deployments  *rolloutTracker
+	statefulsets *rolloutTracker
+	daemonsets   *rolloutTracker
+	jobs         *rolloutTracker
+}
+
+type rolloutTracker struct {
+	k      *k8s
+	kind   string
+	watch  func(context.Context, metav1.ListOptions) (watch.Interface, error)
+	getter func(string) (runtime.Object, error)
+	once   sync.Once
+
+	mu          sync.Mutex
+	watching    bool
+	objects     map[string]runtime.Object
+	subscribers map[string][]chan struct{}
+}
+
+// Discovery decides which files under the configuration directory are read as manifests.
+type Discovery struct {
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+}
+
+// Image is the application image predeploy sets in the manifests.
+type Image struct {
+	Repository string
+	Tag        string
+}
+
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// LintConfig turns lint rules off or changes their severity, by rule ID.
+type LintConfig struct {
+	Disable  []string            `yaml:"disable"`
+	Severity map[string]Severity `yaml:"severity"`
+}
+
+// Violation is a lint rule failed by a processed object.
+type Violation struct {
+	Rule     string
+	Severity Severity
+	Path     string
+	Object   string
+	Message  string
+}
+
+type lintRule struct {
+	ID       string
+	Severity Severity
+	Check    func(object lintObject, objects []lintObject) []string
+}
+
+// kustomizeOverlay is an overlays/<DATACENTER>/ kustomization, built in place of the kustomizations it builds on.
+type kustomizeOverlay struct {
+	dir   string
+	bases map[string]bool
+	built bool
+}
+
+// overlayPatch is a strategic merge or JSON patch from overlays/<DATACENTER>/.
+type overlayPatch struct {
+	path       string
+	target     overlayTarget
+	merge      []byte
+	operations jsonpatch.Patch
+	applied    int32
+}
+
+type overlayTarget struct {
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+}
+
+type lintObject struct {
+	path string
+	obj  runtime.Object
+}
+
+// DevelopmentProfile is how workloads are changed for development clusters, with per-app overrides.
+type DevelopmentProfile struct {
+	DevelopmentTransforms
+	Apps map[string]DevelopmentTransforms `json:"apps,omitempty"`
+}
+
+// DevelopmentTransforms are the pod spec changes of a development profile, unset fields are left as is.
+type DevelopmentTransforms struct {
+	NodeSelector      map[string]string            `json:"nodeSelector,omitempty"`
+	Tolerations       []corev1.Toleration          `json:"tolerations,omitempty"`
+	PriorityClassName string                       `json:"priorityClassName,omitempty"`
+	Replicas          *int32                       `json:"replicas,omitempty"`
+	Resources         *corev1.ResourceRequirements `json:"resources,omitempty"`
+	DisableProbes     *bool                        `json:"disableProbes,omitempty"`
+}
+
+// kustomizationFiles mark a kustomization directory, built with the kustomize API instead of read as manifests
+var kustomizationFiles = []string{"kustomization.yaml", "kustomization.yml", "Kustomization"}
+
+// Variables are the values of ${NAME} substitutions, the environment takes precedence over them.
+type Variables map[string]string
+
+// DigestResolver returns the registry digest of an image.
+type DigestResolver interface {
+	Digest(image string) (string, error)
+}
+
+// WaitOptions controls how Wait follows a rollout.
+type WaitOptions struct {
+	// Timeout is the rollout deadline of every app, 0 waits for the progress deadline
+	Timeout           time.Duration
+	RollbackOnFailure bool
+	// StabilityWindow is how long the new pods are watched after a successful rollout
+	StabilityWindow time.Duration
+	// StallThreshold fails a rollout whose new pods are stuck in ImagePullBackOff,
+	// CrashLoopBackOff and similar states for this long, 0 disables the check
+	StallThreshold time.Duration
+}
+
+type RolloutStatus string
+
+const (
+	RolloutSucceeded  RolloutStatus = "success"
+	RolloutFailed     RolloutStatus = "failed"
+	RolloutRolledBack RolloutStatus = "rolled_back"
+	RolloutCancelled  RolloutStatus = "cancelled"
+)
+
+type ApplyAction string
+
+const (
+	ApplyCreated    ApplyAction = "created"
+	ApplyConfigured ApplyAction = "configured"
+	ApplyUnchanged  ApplyAction = "unchanged"
+	// ApplyRecreated is a job deleted and created again, because its pod template changed
+	ApplyRecreated ApplyAction = "recreated"
+)
+
+// ApplyResult is what server-side apply did to a single object.
+type ApplyResult struct {
+	Path      string
+	Kind      string
+	Name      string
+	Namespace string
+	Action    ApplyAction
+}
+
+// RolloutResult is how the rollout of a single app ended.
+type RolloutResult struct {
+	App      string
+	Kind     string
+	Status   RolloutStatus
+	Duration time.Duration
+	// Revision is the revision running once Wait returns, the restored one after a rollback
+	Revision    string
+	Replicas    int32
+	Err         error
+	Diagnostics *Diagnostics
+}
+
+// Diagnostics describes why a rollout failed: the failing pods of the new
+// revision, the tail of their logs and the latest events.
+type Diagnostics struct {
+	Kind   string
+	Name   string
+	Pods   []PodDiagnostics
+	Events []string
+	Errors []string
+}
+
+type PodDiagnostics struct {
+	Name     string
+	Phase    string
+	Problems []string
+	Logs     map[string]string
 }
 
 type Alerts struct {
@@ -65,4 +285,45 @@ type Group struct {
 
 const (
 	TimedOutReason = "ProgressDeadlineExceeded"
+
+	// RolloutTimeoutAnnotation overrides the -timeout flag for a single app, e.g. "15m"
+	RolloutTimeoutAnnotation = "deploy-checker/rollout-timeout"
+
+	RevisionAnnotation = "deployment.kubernetes.io/revision"
+
+	// PreDeployAnnotation marks a Job that must complete before the apps are rolled out
+	PreDeployAnnotation = "deploy-checker/pre-deploy"
+
+	// OriginalImagesAnnotation is a JSON object with the tagged image of every digest pinned container, by container name
+	OriginalImagesAnnotation = "deploy-checker/original-images"
+
+	// overlaysDir holds a directory of patches per DATACENTER, it is never read as manifests
+	overlaysDir = "overlays"
+
+	// chartFile marks a Helm chart directory, rendered with helmBinary instead of read as manifests.
+	// helmBinary must be on PATH, the Docker image ships a pinned version
+	chartFile  = "Chart.yaml"
+	helmBinary = "helm"
+	// helmHookAnnotation lists the hooks of a chart template, test hooks aren't deployed
+	helmHookAnnotation = "helm.sh/hook"
+	// renderedDir holds the processed objects of a chart or kustomization in parallel mode,
+	// they are written to renderedTempDir under the system temp directory otherwise
+	renderedDir     = ".rendered"
+	renderedTempDir = "deploy-checker-rendered"
+
+	// LintDisableAnnotation is a comma separated list of lint rule IDs skipped for the object
+	LintDisableAnnotation = "deploy-checker/lint-disable"
+
+	pollInterval       = time.Second * 5
+	resyncInterval     = time.Minute
+	watchRetryInterval = time.Second * 5
+
+	stabilityCheckInterval = time.Second * 5
+	stallCheckInterval     = time.Second * 10
+
+	crdEstablishTimeout  = time.Minute
+	crdEstablishInterval = time.Second
+
+	diagnosticsLogLines = 20
+	diagnosticsEvents   = 10
 )
@@ -0,0 +1,50 @@
+package k8s
+
+import (
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"strings"
+)
+
+// processingUnstructured handles kinds the typed scheme doesn't know, like CRDs. They only
+// get the transforms that don't depend on the object's spec.
+func (k *k8s) processingUnstructured(res *resourcesFile) []byte {
+	data, err := yaml.ToJSON(res.data)
+	if err != nil {
+		k.Log().Fatalf("Error while decoding YAML object in file %s. Err was: %s", res.path, err)
+	}
+	obj := &unstructured.Unstructured{}
+	err = obj.UnmarshalJSON(data)
+	if err != nil {
+		k.Log().Fatalf("Error while decoding YAML object in file %s. Err was: %s", res.path, err)
+	}
+	k.Log().Debugf("Processing %s %s from file %s as unstructured object", obj.GetAPIVersion(), obj.GetKind(), res.path)
+	res.resourceType = strings.ToLower(obj.GetKind())
+
+	k.normalizeUnstructured(obj)
+	k.collectObject(res.path, obj)
+	return k.objectToBytes(obj)
+}
+
+func (k *k8s) normalizeUnstructured(obj *unstructured.Unstructured) {
+	unstructured.RemoveNestedField(obj.Object, "status")
+	obj.SetCreationTimestamp(metav1.Now())
+
+	if len(obj.GetLabels()) == 0 {
+		unstructured.RemoveNestedField(obj.Object, "metadata", "labels")
+	}
+	if obj.GetNamespace() != "" || k.namespace == "" {
+		return
+	}
+	// the scope comes from the cluster, a custom resource whose CRD isn't installed yet keeps its namespace unset
+	mapping, err := k.restMapping(obj.GroupVersionKind())
+	if err != nil {
+		k.Log().Debugf("Can't find the scope of %s %s, leaving its namespace unset: %s", obj.GetKind(), obj.GetName(), err)
+		return
+	}
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		obj.SetNamespace(k.namespace)
+	}
+}
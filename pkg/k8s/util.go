@@ -1,81 +1,92 @@
 package k8s
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	log "github.com/sirupsen/logrus"
+	"io"
 	"io/ioutil"
 	v1 "k8s.io/api/apps/v1"
 	appsv1beta1 "k8s.io/api/apps/v1beta1"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
 	corev1 "k8s.io/api/core/v1"
 	extentionsv1beta "k8s.io/api/extensions/v1beta1"
-	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer/json"
+	"k8s.io/apimachinery/pkg/util/yaml"
 	"k8s.io/client-go/kubernetes/scheme"
 	"os"
 	"path/filepath"
 	"strings"
 )
 
-func (k *k8s) findResources(searchDir string) []resourcesFile {
+// findResources reads the manifests under searchDir. Helm charts and kustomizations are rendered when
+// render is set, otherwise their previously processed output in renderedRoot is read.
+func (k *k8s) findResources(searchDir string, render bool) []resourcesFile {
 	var data []resourcesFile
 
 	err := filepath.Walk(searchDir, func(path string, f os.FileInfo, err error) error {
-		if strings.Contains(path, "vendor") {
-			return nil
+		if err != nil {
+			return err
 		}
-		if strings.Contains(path, "monitoring") {
-			return nil
+		rel, err := filepath.Rel(searchDir, path)
+		if err != nil {
+			return err
 		}
-		if strings.Contains(path, "deployment.yml") {
-			dat, err := readFile(path)
-			if err != nil {
-				k.Log().Fatal(err)
+		rel = filepath.ToSlash(rel)
+		if f.IsDir() {
+			if rel == overlaysDir || rel != "." && k.discovery.excluded(rel) {
+				k.Log().Debugf("Skipping directory %s", path)
+				return filepath.SkipDir
 			}
-			k.Log().Debugf("Found deployment file %s", path)
-			data = append(data, resourcesFile{
-				path:         path,
-				data:         dat,
-				resourceType: "deployment",
-			})
-		}
-		if strings.Contains(path, "statefulset.yml") {
-			dat, err := readFile(path)
-			if err != nil {
-				k.Log().Fatal(err)
+			renderer := k.renderer(path)
+			if renderer == nil {
+				return nil
 			}
-			k.Log().Debugf("Found statefulset file %s", path)
-			data = append(data, resourcesFile{
-				path:         path,
-				data:         dat,
-				resourceType: "statefulset",
-			})
-		}
-		if strings.Contains(path, "service.yml") {
-			dat, err := readFile(path)
-			if err != nil {
-				k.Log().Fatal(err)
+			if !render {
+				rendered := k.renderedRoot(path)
+				if _, err := os.Stat(rendered); err == nil {
+					data = append(data, k.findResources(rendered, false)...)
+				}
+				return filepath.SkipDir
 			}
-			k.Log().Debugf("Found service file %s", path)
-			data = append(data, resourcesFile{
-				path:         path,
-				data:         dat,
-				resourceType: "service",
-			})
-		}
-		if strings.Contains(path, "ingress.yml") {
-			dat, err := readFile(path)
+			// objects that are no longer rendered must not be read from a previous run
+			if err := os.RemoveAll(k.outputPath(k.renderedRoot(path))); err != nil {
+				return err
+			}
+			rendered, err := renderer(path)
 			if err != nil {
-				k.Log().Fatal(err)
+				k.Log().Fatalf("Can't render %s: %s", path, err)
 			}
-			k.Log().Debugf("Found ingress file %s", path)
-			data = append(data, resourcesFile{
-				path:         path,
-				data:         dat,
-				resourceType: "ingress",
-			})
+			if !k.parallel && len(rendered) > 0 {
+				k.renderedDirs = append(k.renderedDirs, path)
+			}
+			data = append(data, rendered...)
+			return filepath.SkipDir
+		}
+		if !k.discovery.included(rel) || k.discovery.excluded(rel) {
+			return nil
 		}
+
+		dat, err := readFile(path)
+		if err != nil {
+			k.Log().Fatal(err)
+		}
+		kinds, ok := manifestKinds(dat)
+		if !ok {
+			k.Log().Debugf("Skipping file %s, it is not a kubernetes manifest", path)
+			return nil
+		}
+		k.Log().Debugf("Found %s file %s", kinds[0], path)
+		data = append(data, resourcesFile{
+			path:         path,
+			data:         dat,
+			resourceType: kinds[0],
+		})
 		return nil
 	})
 	if err != nil {
@@ -85,18 +96,56 @@ func (k *k8s) findResources(searchDir string) []resourcesFile {
 	return data
 }
 
-func (k *k8s) writeResourceFile(data []byte, path string) {
+// renderer returns how to render a Helm chart or kustomization directory, nil for plain directories.
+func (k *k8s) renderer(dir string) func(string) ([]resourcesFile, error) {
+	if _, err := os.Stat(filepath.Join(dir, chartFile)); err == nil {
+		return k.renderChart
+	}
+	if isKustomization(dir) {
+		return k.buildKustomization
+	}
+	return nil
+}
+
+// RenderedDirs returns the Helm charts and kustomizations PrepareResources rendered outside -dir.
+// Only -apply deploys them, kubectl apply of -dir doesn't see their output. In parallel mode
+// it is written under .deploy/<DATACENTER>/ with the other manifests, so none are returned.
+func (k *k8s) RenderedDirs() []string {
+	return k.renderedDirs
+}
+
+// renderedRoot returns where the processed objects of a Helm chart or kustomization in dir are
+// written. In parallel mode it is <dir>/.rendered/, which outputPath moves to .deploy/<DATACENTER>/,
+// otherwise a directory under the system temp directory, so that the source tree isn't changed.
+func (k *k8s) renderedRoot(dir string) string {
+	if k.parallel {
+		return filepath.Join(dir, renderedDir)
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		k.Log().Fatal(err)
+	}
+	return filepath.Join(os.TempDir(), renderedTempDir, abs)
+}
+
+// outputPath returns where a processed resource file is written, .deploy/<DATACENTER>/ in parallel mode.
+func (k *k8s) outputPath(path string) string {
 	if k.parallel {
 		if len(os.Getenv("DATACENTER")) > 0 {
 			path = os.Getenv("DATACENTER") + "/" + path
 		}
 		path = ".deploy" + "/" + path
+	}
+	return path
+}
 
-		k.Log().Debugf("Creating directory %s", filepath.Dir(path))
-		err := os.MkdirAll(filepath.Dir(path), 0755)
-		if err != nil {
-			k.Log().Fatal(err)
-		}
+func (k *k8s) writeResourceFile(data []byte, path string) {
+	path = k.outputPath(path)
+	// parallel output and rendered charts are written to directories that may not exist yet
+	k.Log().Debugf("Creating directory %s", filepath.Dir(path))
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		k.Log().Fatal(err)
 	}
 
 	f, err := os.Create(path)
@@ -130,6 +179,59 @@ func (k *k8s) Log() *log.Entry {
 	return k.checker.Log().WithField("context", "k8s")
 }
 
+// splitDocuments returns the YAML documents of a file in their original order,
+// skipping the ones that hold nothing but comments.
+func splitDocuments(data []byte) ([][]byte, error) {
+	var documents [][]byte
+	reader := yaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(data)))
+	for {
+		document, err := reader.Read()
+		if err == io.EOF {
+			return documents, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		document = trimSeparators(document)
+		if isEmptyDocument(document) {
+			continue
+		}
+		documents = append(documents, document)
+	}
+}
+
+// trimSeparators drops the "---" lines the reader leaves at the start of the first document.
+func trimSeparators(document []byte) []byte {
+	for {
+		line := document
+		end := bytes.IndexByte(document, '\n')
+		if end >= 0 {
+			line = document[:end]
+		}
+		if string(bytes.TrimRight(line, " \t\r")) != "---" {
+			return document
+		}
+		if end < 0 {
+			return nil
+		}
+		document = document[end+1:]
+	}
+}
+
+func isEmptyDocument(document []byte) bool {
+	for _, line := range strings.Split(string(document), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			return false
+		}
+	}
+	return true
+}
+
+func joinDocuments(documents [][]byte) []byte {
+	return bytes.Join(documents, []byte("---\n"))
+}
+
 func readFile(path string) ([]byte, error) {
 	dat, err := ioutil.ReadFile(path)
 	if err != nil {
@@ -167,7 +269,7 @@ func (k *k8s) objectToBytes(obj runtime.Object) []byte {
 }
 
 func (k *k8s) getKubernetesStatefulset(name string, namespace string) *appsv1beta1.StatefulSet {
-	obj, err := k.client.AppsV1beta1().StatefulSets(namespace).Get(name, metav1.GetOptions{})
+	obj, err := k.client.AppsV1beta1().StatefulSets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
 	if err != nil {
 		k.Log().Fatal(err)
 	}
@@ -184,73 +286,51 @@ func (k *k8s) convertResources(res *resourcesFile) {
 	switch res.resourceType {
 	case "deployment":
 		dst := &v1.Deployment{}
-		if err := scheme.Scheme.Convert(obj, dst, nil); err != nil {
+		if err := convertObject(obj, dst); err != nil {
 			k.Log().Fatal(err)
 		}
 		dst.TypeMeta.APIVersion = "apps/v1"
 		dst.TypeMeta.Kind = "Deployment"
+		dst.Spec.Selector = defaultSelector(dst.Spec.Selector, dst.Spec.Template.Labels)
 		res.data = k.objectToBytes(dst)
 	case "statefulset":
 		dst := &v1.StatefulSet{}
 		dst.TypeMeta.APIVersion = "apps/v1"
 		dst.TypeMeta.Kind = "Statefulset"
 		res.data = k.objectToBytes(dst)
+	case "daemonset":
+		dst := &v1.DaemonSet{}
+		if err := convertObject(obj, dst); err != nil {
+			k.Log().Fatal(err)
+		}
+		dst.TypeMeta.APIVersion = "apps/v1"
+		dst.TypeMeta.Kind = "DaemonSet"
+		dst.Spec.Selector = defaultSelector(dst.Spec.Selector, dst.Spec.Template.Labels)
+		res.data = k.objectToBytes(dst)
 	}
 }
 
-func (k *k8s) replaceNodeSelector(res *resourcesFile) {
-	decode := scheme.Codecs.UniversalDeserializer().Decode
-	obj, _, err := decode(res.data, nil, nil)
+// convertObject copies obj into dst field by field. The scheme has no conversions between
+// extensions/v1beta1 and apps/v1, but their fields share the same names.
+func convertObject(obj runtime.Object, dst runtime.Object) error {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
 	if err != nil {
-		k.Log().Fatalf("ReplaceNodeSelector Error while decoding YAML object in file %s. Err was: %s", res.path, err)
-	}
-	nodeSelector := map[string]string{"kubernetes.io/role": "development"}
-
-	switch o := obj.(type) {
-	case *v1.Deployment:
-		o.Spec.Template.Spec.NodeSelector = nodeSelector
-		res.data = k.objectToBytes(o)
-	case *v1.StatefulSet:
-		o.Spec.Template.Spec.NodeSelector = nodeSelector
-		res.data = k.objectToBytes(o)
+		return err
 	}
+	return runtime.DefaultUnstructuredConverter.FromUnstructured(content, dst)
 }
 
-func (k *k8s) cleanupResources(res *resourcesFile) {
-	decode := scheme.Codecs.UniversalDeserializer().Decode
-	obj, _, err := decode(res.data, nil, nil)
-	if err != nil {
-		k.Log().Fatalf("Cleanup Resources Error while decoding YAML object in file %s. Err was: %s", res.path, err)
+// defaultSelector selects the pod template labels when a selector isn't set. extensions/v1beta1
+// defaulted it this way, apps/v1 requires it.
+func defaultSelector(selector *metav1.LabelSelector, labels map[string]string) *metav1.LabelSelector {
+	if selector != nil {
+		return selector
 	}
-
-	var containers []corev1.Container
-
-	resources := corev1.ResourceRequirements{
-		Limits: corev1.ResourceList{
-			"memory": resource.MustParse("4Gi"),
-		},
-		Requests: corev1.ResourceList{
-			"cpu":    resource.MustParse("1m"),
-			"memory": resource.MustParse("1Mi"),
-		},
-	}
-
-	switch o := obj.(type) {
-	case *v1.Deployment:
-		for _, c := range o.Spec.Template.Spec.Containers {
-			c.Resources = resources
-			containers = append(containers, c)
-		}
-		o.Spec.Template.Spec.Containers = containers
-		res.data = k.objectToBytes(o)
-	case *v1.StatefulSet:
-		for _, c := range o.Spec.Template.Spec.Containers {
-			c.Resources = resources
-			containers = append(containers, c)
-		}
-		o.Spec.Template.Spec.Containers = containers
-		res.data = k.objectToBytes(o)
+	matchLabels := make(map[string]string)
+	for key, value := range labels {
+		matchLabels[key] = value
 	}
+	return &metav1.LabelSelector{MatchLabels: matchLabels}
 }
 
 func (k *k8s) updateTimestamp(res *resourcesFile) {
@@ -270,20 +350,38 @@ func (k *k8s) updateTimestamp(res *resourcesFile) {
 		o.ObjectMeta.CreationTimestamp = metav1.Now()
 		o.Spec.Template.CreationTimestamp = metav1.Now()
 		res.data = k.objectToBytes(o)
+	case *v1.DaemonSet:
+		o.CreationTimestamp = metav1.Now()
+		o.ObjectMeta.CreationTimestamp = metav1.Now()
+		o.Spec.Template.CreationTimestamp = metav1.Now()
+		res.data = k.objectToBytes(o)
+	case *batchv1.Job:
+		o.ObjectMeta.CreationTimestamp = metav1.Now()
+		o.Spec.Template.CreationTimestamp = metav1.Now()
+		res.data = k.objectToBytes(o)
+	case *batchv1beta1.CronJob:
+		o.ObjectMeta.CreationTimestamp = metav1.Now()
+		o.Spec.JobTemplate.CreationTimestamp = metav1.Now()
+		o.Spec.JobTemplate.Spec.Template.CreationTimestamp = metav1.Now()
+		res.data = k.objectToBytes(o)
 	case *corev1.Service:
 		o.ObjectMeta.CreationTimestamp = metav1.Now()
 		res.data = k.objectToBytes(o)
 	case *extentionsv1beta.Ingress:
 		o.ObjectMeta.CreationTimestamp = metav1.Now()
 		res.data = k.objectToBytes(o)
+	default:
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			return
+		}
+		accessor.SetCreationTimestamp(metav1.Now())
+		res.data = k.objectToBytes(obj)
 	}
 }
 
-func (k *k8s) prepareForDevelopment(res *resourcesFile) {
-	k.replaceNodeSelector(res)
-	k.cleanupResources(res)
-}
-
+// fixReplicas keeps the replicas of a running app, so that a deploy doesn't scale it. A manifest
+// without replicas is left as is, e.g. when a HorizontalPodAutoscaler manages them.
 func (k *k8s) fixReplicas(res *resourcesFile) {
 	decode := scheme.Codecs.UniversalDeserializer().Decode
 	obj, _, err := decode(res.data, nil, nil)
@@ -292,10 +390,14 @@ func (k *k8s) fixReplicas(res *resourcesFile) {
 	}
 	switch o := obj.(type) {
 	case *v1.Deployment:
+		if o.Spec.Replicas == nil {
+			k.Log().Debugf("Deployment %s has no replicas in repository, leaving them to the cluster", o.Name)
+			return
+		}
 		if k.isResourceExist(o.Name, o.Namespace, res.resourceType) {
 			k.Log().Debugf("Deployment %s exist in namespace %s", o.Name, o.Namespace)
 			deployment := k.getKubernetesDeployment(o.Name, o.Namespace)
-			if *deployment.Spec.Replicas != *o.Spec.Replicas {
+			if deployment.Spec.Replicas != nil && *deployment.Spec.Replicas != *o.Spec.Replicas {
 				k.Log().Infof("Deployment %s is changed. Replicas in repository %d and %d replicas in k8s", o.Name, *o.Spec.Replicas,
 					*deployment.Spec.Replicas)
 				*o.Spec.Replicas = *deployment.Spec.Replicas
@@ -303,10 +405,14 @@ func (k *k8s) fixReplicas(res *resourcesFile) {
 			}
 		}
 	case *v1.StatefulSet:
+		if o.Spec.Replicas == nil {
+			k.Log().Debugf("Statefulset %s has no replicas in repository, leaving them to the cluster", o.Name)
+			return
+		}
 		if k.isResourceExist(o.Name, o.Namespace, res.resourceType) {
 			k.Log().Debugf("Statefulset %s exist in namespace %s", o.Name, o.Namespace)
 			statefulset := k.getKubernetesStatefulset(o.Name, o.Namespace)
-			if *statefulset.Spec.Replicas != *o.Spec.Replicas {
+			if statefulset.Spec.Replicas != nil && *statefulset.Spec.Replicas != *o.Spec.Replicas {
 				k.Log().Debugf("Current deployment is changed. Replicas in repository %d and %d replicas in k8s", *o.Spec.Replicas,
 					*statefulset.Spec.Replicas)
 				*o.Spec.Replicas = *statefulset.Spec.Replicas
@@ -320,9 +426,13 @@ func (k *k8s) isResourceExist(name string, namespace string, resType string) boo
 	var err error
 	switch resType {
 	case "deployment":
-		_, err = k.client.AppsV1().Deployments(namespace).Get(name, metav1.GetOptions{})
+		_, err = k.client.AppsV1().Deployments(namespace).Get(context.TODO(), name, metav1.GetOptions{})
 	case "statefulset":
-		_, err = k.client.AppsV1().StatefulSets(namespace).Get(name, metav1.GetOptions{})
+		_, err = k.client.AppsV1().StatefulSets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	case "daemonset":
+		_, err = k.client.AppsV1().DaemonSets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	case "job":
+		_, err = k.client.BatchV1().Jobs(namespace).Get(context.TODO(), name, metav1.GetOptions{})
 	}
 	if err != nil {
 		return false
@@ -0,0 +1,223 @@
+package k8s
+
+import (
+	"github.com/sirupsen/logrus"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testChecker struct{}
+
+func (testChecker) Version() string {
+	return "test"
+}
+
+func (testChecker) Log() *logrus.Entry {
+	return logrus.NewEntry(logrus.StandardLogger())
+}
+
+func newTestK8s() *k8s {
+	return &k8s{checker: testChecker{}, namespace: "default"}
+}
+
+func TestConvertResources(t *testing.T) {
+	tests := []struct {
+		name         string
+		resourceType string
+		data         string
+		selector     map[string]string
+		check        func(t *testing.T, obj interface{})
+	}{
+		{
+			name:         "deployment",
+			resourceType: "deployment",
+			data: `apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: web
+  namespace: front
+spec:
+  replicas: 3
+  selector:
+    matchLabels:
+      app: web
+  template:
+    metadata:
+      labels:
+        app: web
+        tier: front
+    spec:
+      containers:
+      - name: web
+        image: nginx:1.17
+`,
+			selector: map[string]string{"app": "web"},
+			check: func(t *testing.T, obj interface{}) {
+				deployment, ok := obj.(*appsv1.Deployment)
+				if !ok {
+					t.Fatalf("got %T, want *appsv1.Deployment", obj)
+				}
+				if deployment.Name != "web" || deployment.Namespace != "front" {
+					t.Errorf("got %s/%s, want front/web", deployment.Namespace, deployment.Name)
+				}
+				if deployment.Spec.Replicas == nil || *deployment.Spec.Replicas != 3 {
+					t.Errorf("replicas were not converted: %v", deployment.Spec.Replicas)
+				}
+				if image := deployment.Spec.Template.Spec.Containers[0].Image; image != "nginx:1.17" {
+					t.Errorf("got image %s, want nginx:1.17", image)
+				}
+			},
+		},
+		{
+			name:         "daemonset without selector",
+			resourceType: "daemonset",
+			data: `apiVersion: extensions/v1beta1
+kind: DaemonSet
+metadata:
+  name: agent
+spec:
+  templateGeneration: 4
+  updateStrategy:
+    type: RollingUpdate
+    rollingUpdate:
+      maxUnavailable: 2
+  template:
+    metadata:
+      labels:
+        app: agent
+    spec:
+      containers:
+      - name: agent
+        image: agent:2.0
+`,
+			selector: map[string]string{"app": "agent"},
+			check: func(t *testing.T, obj interface{}) {
+				ds, ok := obj.(*appsv1.DaemonSet)
+				if !ok {
+					t.Fatalf("got %T, want *appsv1.DaemonSet", obj)
+				}
+				if ds.Name != "agent" {
+					t.Errorf("got name %s, want agent", ds.Name)
+				}
+				if ds.Spec.UpdateStrategy.Type != appsv1.RollingUpdateDaemonSetStrategyType {
+					t.Errorf("got update strategy %s, want RollingUpdate", ds.Spec.UpdateStrategy.Type)
+				}
+				rolling := ds.Spec.UpdateStrategy.RollingUpdate
+				if rolling == nil || rolling.MaxUnavailable == nil || rolling.MaxUnavailable.IntValue() != 2 {
+					t.Errorf("maxUnavailable was not converted: %+v", rolling)
+				}
+				if image := ds.Spec.Template.Spec.Containers[0].Image; image != "agent:2.0" {
+					t.Errorf("got image %s, want agent:2.0", image)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := &resourcesFile{path: tt.name + ".yaml", data: []byte(tt.data), resourceType: tt.resourceType}
+			newTestK8s().convertResources(res)
+			obj, gvk, err := scheme.Codecs.UniversalDeserializer().Decode(res.data, nil, nil)
+			if err != nil {
+				t.Fatalf("converted object doesn't decode: %s\n%s", err, res.data)
+			}
+			if gvk.GroupVersion() != appsv1.SchemeGroupVersion {
+				t.Errorf("got %s, want apps/v1", gvk.GroupVersion())
+			}
+			var selector *metav1.LabelSelector
+			switch o := obj.(type) {
+			case *appsv1.Deployment:
+				selector = o.Spec.Selector
+			case *appsv1.DaemonSet:
+				selector = o.Spec.Selector
+			}
+			if selector == nil || !reflect.DeepEqual(selector.MatchLabels, tt.selector) {
+				t.Errorf("got selector %v, want %v", selector, tt.selector)
+			}
+			tt.check(t, obj)
+		})
+	}
+}
+
+func TestSplitDocuments(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []string
+	}{
+		{
+			name: "single document",
+			data: "kind: Service\n",
+			want: []string{"kind: Service\n"},
+		},
+		{
+			name: "leading separator",
+			data: "---\nkind: Service\n---\nkind: Deployment\n",
+			want: []string{"kind: Service\n", "kind: Deployment\n"},
+		},
+		{
+			name: "empty documents",
+			data: "---\n---\nkind: Service\n---\n\n---\nkind: Deployment\n---\n",
+			want: []string{"kind: Service\n", "kind: Deployment\n"},
+		},
+		{
+			name: "comment only documents",
+			data: "# generated\n---\nkind: Service\n---\n# kind: Deployment\n  # indented comment\n",
+			want: []string{"kind: Service\n"},
+		},
+		{
+			name: "comments are kept in documents",
+			data: "# service\nkind: Service\n",
+			want: []string{"# service\nkind: Service\n"},
+		},
+		{
+			name: "separator inside block scalar",
+			data: "kind: ConfigMap\ndata:\n  config: |\n    a: 1\n    ---\n    b: 2\n---\nkind: Service\n",
+			want: []string{"kind: ConfigMap\ndata:\n  config: |\n    a: 1\n    ---\n    b: 2\n", "kind: Service\n"},
+		},
+		{
+			name: "separator with trailing spaces",
+			data: "kind: Service\n---  \nkind: Deployment\n",
+			want: []string{"kind: Service\n", "kind: Deployment\n"},
+		},
+		{
+			name: "empty file",
+			data: "",
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			documents, err := splitDocuments([]byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, document := range documents {
+				got = append(got, string(document))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFixReplicasWithoutReplicas(t *testing.T) {
+	for _, kind := range []string{"Deployment", "StatefulSet"} {
+		t.Run(kind, func(t *testing.T) {
+			data := []byte("apiVersion: apps/v1\nkind: " + kind + "\nmetadata:\n  name: web\nspec:\n  template:\n    spec:\n      containers:\n      - name: web\n        image: web:v1\n")
+			res := &resourcesFile{path: "web.yaml", data: data, resourceType: strings.ToLower(kind)}
+			// the app isn't looked up in the cluster, a HorizontalPodAutoscaler manages its replicas
+			newTestK8s().fixReplicas(res)
+			if !reflect.DeepEqual(res.data, data) {
+				t.Errorf("manifest changed to\n%s", res.data)
+			}
+		})
+	}
+}
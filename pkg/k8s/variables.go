@@ -0,0 +1,83 @@
+package k8s
+
+import (
+	yml "gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// variablePattern matches ${NAME}, and $${NAME} which is written as a literal ${NAME}.
+var variablePattern = regexp.MustCompile(`\$(\$?)\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// LoadVariables reads substitution values from a flat YAML map.
+func LoadVariables(path string) (Variables, error) {
+	variables := Variables{}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return variables, err
+	}
+	err = yml.UnmarshalStrict(data, &variables)
+	return variables, err
+}
+
+// lookup resolves a variable from the environment first and the values file second.
+func (v Variables) lookup(name string) (string, string, bool) {
+	if value, ok := os.LookupEnv(name); ok {
+		return value, "environment", true
+	}
+	value, ok := v[name]
+	return value, "values file", ok
+}
+
+// substitute replaces ${NAME} in data. It returns where every substituted name was
+// resolved from and the sorted names that couldn't be resolved, which are left as is.
+func (v Variables) substitute(data []byte) ([]byte, map[string]string, []string) {
+	substituted := make(map[string]string)
+	missing := make(map[string]bool)
+	data = variablePattern.ReplaceAllFunc(data, func(match []byte) []byte {
+		groups := variablePattern.FindSubmatch(match)
+		name := string(groups[2])
+		if len(groups[1]) > 0 {
+			return []byte("${" + name + "}")
+		}
+		value, source, ok := v.lookup(name)
+		if !ok {
+			missing[name] = true
+			return match
+		}
+		substituted[name] = source
+		return []byte(value)
+	})
+
+	var names []string
+	for name := range missing {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return data, substituted, names
+}
+
+// substituteVariables replaces ${NAME} in a file before any document is decoded,
+// so that the typed decoder validates the substituted values. Unresolved names are fatal.
+func (k *k8s) substituteVariables(res *resourcesFile) {
+	if k.variables == nil {
+		return
+	}
+	data, substituted, missing := k.variables.substitute(res.data)
+	if len(missing) > 0 {
+		k.Log().Fatalf("Unresolved variables in file %s: %s", res.path, strings.Join(missing, ", "))
+	}
+	res.data = data
+
+	var names []string
+	for name := range substituted {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		k.Log().Debugf("Substituted ${%s} in file %s from %s", name, res.path, substituted[name])
+	}
+}
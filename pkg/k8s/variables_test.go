@@ -0,0 +1,116 @@
+package k8s
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSubstitute(t *testing.T) {
+	os.Setenv("DEPLOY_CHECKER_TEST_TAG", "from-env")
+	os.Setenv("DEPLOY_CHECKER_TEST_EMPTY", "")
+	defer os.Unsetenv("DEPLOY_CHECKER_TEST_TAG")
+	defer os.Unsetenv("DEPLOY_CHECKER_TEST_EMPTY")
+	variables := Variables{
+		"DEPLOY_CHECKER_TEST_TAG":   "from-values",
+		"DEPLOY_CHECKER_TEST_HOST":  "example.com",
+		"DEPLOY_CHECKER_TEST_EMPTY": "from-values",
+	}
+
+	tests := []struct {
+		name        string
+		data        string
+		want        string
+		substituted map[string]string
+		missing     []string
+	}{
+		{
+			name:        "environment takes precedence over the values file",
+			data:        "image: web:${DEPLOY_CHECKER_TEST_TAG}",
+			want:        "image: web:from-env",
+			substituted: map[string]string{"DEPLOY_CHECKER_TEST_TAG": "environment"},
+		},
+		{
+			name:        "empty environment variable is set",
+			data:        "value: '${DEPLOY_CHECKER_TEST_EMPTY}'",
+			want:        "value: ''",
+			substituted: map[string]string{"DEPLOY_CHECKER_TEST_EMPTY": "environment"},
+		},
+		{
+			name:        "values file",
+			data:        "host: ${DEPLOY_CHECKER_TEST_HOST}\nurl: https://${DEPLOY_CHECKER_TEST_HOST}/",
+			want:        "host: example.com\nurl: https://example.com/",
+			substituted: map[string]string{"DEPLOY_CHECKER_TEST_HOST": "values file"},
+		},
+		{
+			name:        "escaped variable",
+			data:        "command: echo $${DEPLOY_CHECKER_TEST_HOST} ${DEPLOY_CHECKER_TEST_HOST}",
+			want:        "command: echo ${DEPLOY_CHECKER_TEST_HOST} example.com",
+			substituted: map[string]string{"DEPLOY_CHECKER_TEST_HOST": "values file"},
+		},
+		{
+			name:        "escaped undefined variable",
+			data:        "command: echo $${DEPLOY_CHECKER_TEST_UNDEFINED}",
+			want:        "command: echo ${DEPLOY_CHECKER_TEST_UNDEFINED}",
+			substituted: map[string]string{},
+		},
+		{
+			name:        "undefined variables",
+			data:        "a: ${DEPLOY_CHECKER_TEST_Z}\nb: ${DEPLOY_CHECKER_TEST_A}\nc: ${DEPLOY_CHECKER_TEST_Z}\nd: ${DEPLOY_CHECKER_TEST_HOST}",
+			want:        "a: ${DEPLOY_CHECKER_TEST_Z}\nb: ${DEPLOY_CHECKER_TEST_A}\nc: ${DEPLOY_CHECKER_TEST_Z}\nd: example.com",
+			substituted: map[string]string{"DEPLOY_CHECKER_TEST_HOST": "values file"},
+			missing:     []string{"DEPLOY_CHECKER_TEST_A", "DEPLOY_CHECKER_TEST_Z"},
+		},
+		{
+			name:        "shell style variables are left alone",
+			data:        "command: echo $HOME ${1} ${}",
+			want:        "command: echo $HOME ${1} ${}",
+			substituted: map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, substituted, missing := variables.substitute([]byte(tt.data))
+			if string(got) != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if !reflect.DeepEqual(substituted, tt.substituted) {
+				t.Errorf("got substituted %v, want %v", substituted, tt.substituted)
+			}
+			if !reflect.DeepEqual(missing, tt.missing) {
+				t.Errorf("got missing %v, want %v", missing, tt.missing)
+			}
+		})
+	}
+}
+
+func TestLoadVariables(t *testing.T) {
+	dir, err := ioutil.TempDir("", "variables")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "values.yaml")
+	if err := ioutil.WriteFile(path, []byte("HOST: example.com\nREPLICAS: 3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := LoadVariables(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Variables{"HOST": "example.com", "REPLICAS": "3"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("HOST:\n  name: example.com\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadVariables(path); err == nil {
+		t.Error("nested values didn't return an error")
+	}
+}